@@ -18,6 +18,7 @@ package v1
 
 import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -28,21 +29,561 @@ type KubexposeSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	// will be used to create the Service
-	SourceDeploymentName string `json:"sourceDeployment"`
-	PortToExpose         int    `json:"port"`
-	TargetNamespace      string `json:"targetNamespace"`
+	// workload (or Service) to be exposed
+	//+optional
+	Source *SourceReference `json:"source,omitempty"`
+
+	// Deprecated: use source instead. equivalent to a source of kind Deployment - the admission webhook replaces the source with it
+	// and clears it
+	//+optional
+	SourceDeploymentName string `json:"sourceDeployment,omitempty"`
+
+	// port to expose. use ports to expose multiple ports
+	//+kubebuilder:validation:Minimum=1
+	//+kubebuilder:validation:Maximum=65535
+	//+optional
+	PortToExpose int `json:"port,omitempty"`
+
+	// tunnel protocol for port - use tcp to expose databases, Redis, SSH etc. defaults to http.
+	// not applicable to ports, which specify the protocol for each port
+	//+kubebuilder:validation:Enum=http;tcp;tls
+	//+optional
+	Protocol string `json:"protocol,omitempty"`
+
+	// ports to expose - each one gets a tunnel (and public url) of its own. takes precedence over port
+	//+optional
+	//+listType=map
+	//+listMapKey=name
+	Ports []PortSpec `json:"ports,omitempty"`
+
+	// namespace of the source. defaults to the namespace of the Kubexpose resource.
+	// the Service and tunnel Deployment are created in this namespace
+	//+optional
+	TargetNamespace string `json:"targetNamespace,omitempty"`
+
+	// tunnel provider used to expose the Service. defaults to ngrok
+	//+kubebuilder:validation:Enum=ngrok;cloudflared
+	//+kubebuilder:default=ngrok
+	//+optional
+	Provider string `json:"provider,omitempty"`
+
+	// Secret (in the target namespace) with the authtoken for the tunnel provider. defaults to the Secret configured for the operator (if any).
+	// the tunnel is started anonymously if there is no authtoken
+	//+optional
+	AuthSecretRef *SecretKeyReference `json:"authSecretRef,omitempty"`
+
+	// restricts who can access the public url. only applicable to http ports
+	//+optional
+	Access *AccessSpec `json:"access,omitempty"`
+
+	// how long the public url is available for, counting from the creation of the Kubexpose resource e.g. 2h
+	//+optional
+	TTL *metav1.Duration `json:"ttl,omitempty"`
+
+	// when the public url stops being available. if ttl is also specified, whichever comes first is used
+	//+optional
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+
+	// what happens once the Kubexpose resource expires - Teardown (default) scales down the tunnel, Delete deletes the Kubexpose resource
+	//+kubebuilder:validation:Enum=Teardown;Delete
+	//+optional
+	ExpiryAction string `json:"expiryAction,omitempty"`
+
+	// windows during which the public url is available. the tunnel is scaled down outside of them
+	//+optional
+	Schedule *ScheduleSpec `json:"schedule,omitempty"`
+
+	// reserved hostname (custom domain) for port e.g. app.example.com. the public url stays the same when the tunnel is restarted.
+	// requires an authtoken. not applicable to ports, which specify the hostname for each port
+	//+optional
+	Hostname string `json:"hostname,omitempty"`
+
+	// reserved subdomain of the provider domain for port e.g. myapp for https://myapp.ngrok.io. ngrok only.
+	// requires an authtoken. not applicable to ports, which specify the subdomain for each port
+	//+optional
+	Subdomain string `json:"subdomain,omitempty"`
+
+	// region in which the tunnel is hosted. ngrok only - defaults to us
+	//+kubebuilder:validation:Enum=us;eu;ap;au;sa;jp;in
+	//+optional
+	Region string `json:"region,omitempty"`
+
+	// webhooks which are sent an HTTP POST request when a public url is assigned, changes or is revoked
+	//+optional
+	//+listType=map
+	//+listMapKey=name
+	Notify []NotifyTarget `json:"notify,omitempty"`
+
+	// writes the public url into a ConfigMap or Secret (in the target namespace) for the applications which need to know it
+	//+optional
+	PublishTo *PublishSpec `json:"publishTo,omitempty"`
+}
+
+// PublishSpec defines where the public url is published. the ConfigMap and Secret are created by kubexpose and kept in sync with the status
+type PublishSpec struct {
+	// ConfigMap to which the public url is written
+	//+optional
+	ConfigMap *PublishTarget `json:"configMap,omitempty"`
+
+	// Secret to which the public url is written
+	//+optional
+	Secret *PublishTarget `json:"secret,omitempty"`
+
+	// rolls out the source when the published url changes, by annotating its Pod template. only applicable to Deployment, StatefulSet and DaemonSet
+	//+optional
+	RolloutSource bool `json:"rolloutSource,omitempty"`
+}
+
+// PublishTarget is a ConfigMap or Secret key to which the public url is written
+type PublishTarget struct {
+	// name of the ConfigMap or Secret. it must not exist, unless it was created by kubexpose for this resource
+	//+kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// key for the public url (of the first port). defaults to url. with ports, the url of each port is also written to <key>-<port name>
+	//+kubebuilder:validation:Pattern=`^[-._a-zA-Z0-9]+$`
+	//+optional
+	Key string `json:"key,omitempty"`
+}
+
+// DefaultPublishKey is the key of the public url in the ConfigMap or Secret referred to by publishTo
+const DefaultPublishKey = "url"
+
+// NotifyTarget is a webhook which is notified of changes to the public urls
+type NotifyTarget struct {
+	// name of the target. must be unique - it's used to report the delivery state in the status
+	//+kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	//+kubebuilder:validation:MaxLength=63
+	Name string `json:"name"`
+
+	// http(s) url to which the notifications are posted
+	//+kubebuilder:validation:MinLength=1
+	URL string `json:"url"`
+
+	// name of a Secret (in the target namespace) whose keys and values are sent as HTTP headers e.g. Authorization
+	//+optional
+	HeadersSecretName string `json:"headersSecretName,omitempty"`
+
+	// Go template for the JSON request body. the fields are .Event, .Name, .Namespace, .Port, .URL and .PreviousURL -
+	// use the json function to quote them e.g. {"text": {{ printf "%s is at %s" .Name .URL | json }}}.
+	// defaults to a JSON object with all the fields
+	//+optional
+	Template string `json:"template,omitempty"`
+
+	// events the target is notified of. defaults to all of them
+	//+optional
+	Events []NotifyEvent `json:"events,omitempty"`
+}
+
+// NotifyEvent is a change to the public url of a port
+//+kubebuilder:validation:Enum=URLAssigned;URLChanged;URLRevoked
+type NotifyEvent string
+
+const (
+	// NotifyURLAssigned means that the port got a public url
+	NotifyURLAssigned NotifyEvent = "URLAssigned"
+	// NotifyURLChanged means that the public url of the port changed e.g. since the tunnel was restarted
+	NotifyURLChanged NotifyEvent = "URLChanged"
+	// NotifyURLRevoked means that the port does not have a public url anymore e.g. since the tunnel was stopped
+	NotifyURLRevoked NotifyEvent = "URLRevoked"
+)
+
+// Notifies tells whether the target is notified of the event
+func (t *NotifyTarget) Notifies(event NotifyEvent) bool {
+	if len(t.Events) == 0 {
+		return true
+	}
+	for _, e := range t.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// ScheduleSpec defines when the public url is available
+type ScheduleSpec struct {
+	// IANA time zone in which the cron expressions of the windows are evaluated e.g. Europe/Berlin. defaults to UTC
+	//+optional
+	TimeZone string `json:"timeZone,omitempty"`
+
+	// the public url is available if any of the windows is open
+	//+kubebuilder:validation:MinItems=1
+	Windows []ScheduleWindow `json:"windows"`
+}
+
+// ScheduleWindow is a time window which opens on a cron schedule e.g. "0 9 * * 1-5" for 9h (weekdays 09:00-18:00)
+type ScheduleWindow struct {
+	// cron expression (minute, hour, day of month, month, day of week) or descriptor (e.g. @daily) for when the window opens
+	//+kubebuilder:validation:MinLength=1
+	Cron string `json:"cron"`
+
+	// how long the window stays open after it opens e.g. 9h or 30m
+	Duration metav1.Duration `json:"duration"`
+}
+
+// supported expiry actions
+const (
+	ExpiryActionTeardown = "Teardown"
+	ExpiryActionDelete   = "Delete"
+)
+
+// Expiry returns when the Kubexpose resource expires, taking into account ttl and expiresAt. nil is returned if it does not expire
+func (k *Kubexpose) Expiry() *metav1.Time {
+	var expiry *metav1.Time
+
+	if k.Spec.TTL != nil {
+		t := metav1.NewTime(k.CreationTimestamp.Add(k.Spec.TTL.Duration))
+		expiry = &t
+	}
+
+	if k.Spec.ExpiresAt != nil && (expiry == nil || k.Spec.ExpiresAt.Before(expiry)) {
+		t := *k.Spec.ExpiresAt
+		expiry = &t
+	}
+
+	return expiry
+}
+
+// AccessSpec restricts access to the public url. the restrictions are enforced by proxies which run alongside the tunnel
+type AccessSpec struct {
+	// requires a username and password to access the public url
+	//+optional
+	BasicAuth *BasicAuthSpec `json:"basicAuth,omitempty"`
+
+	// requires users to sign in with an OAuth/OIDC provider to access the public url
+	//+optional
+	OAuth *OAuthSpec `json:"oauth,omitempty"`
+
+	// only clients with an IP address in these ranges (e.g. 203.0.113.0/24) can access the public url
+	//+optional
+	AllowCIDRs []string `json:"allowCIDRs,omitempty"`
+
+	// clients with an IP address in these ranges can't access the public url. takes precedence over allowCIDRs
+	//+optional
+	DenyCIDRs []string `json:"denyCIDRs,omitempty"`
+}
+
+// BasicAuthSpec refers to the credentials for basic auth
+type BasicAuthSpec struct {
+	// Secret (in the target namespace) with the credentials in htpasswd format. the key defaults to auth
+	SecretRef SecretKeyReference `json:"secretRef"`
+}
+
+// OAuthSpec configures the OAuth/OIDC provider users sign in with
+type OAuthSpec struct {
+	// OAuth provider
+	//+kubebuilder:validation:Enum=google;github;gitlab;azure;oidc
+	Provider string `json:"provider"`
+
+	// issuer url of the OIDC provider. required for provider oidc
+	//+optional
+	IssuerURL string `json:"issuerURL,omitempty"`
+
+	// name of the Secret (in the target namespace) with the client-id, client-secret and cookie-secret keys
+	//+kubebuilder:validation:MinLength=1
+	SecretName string `json:"secretName"`
+
+	// only users with an email address in these domains can access the public url. all the users who can sign in are allowed if not specified
+	//+optional
+	AllowedEmailDomains []string `json:"allowedEmailDomains,omitempty"`
+}
+
+// SecretKeyReference refers to a key in a Secret
+type SecretKeyReference struct {
+	// name of the Secret
+	//+kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// key in the Secret. the default depends on what the Secret is used for
+	//+optional
+	Key string `json:"key,omitempty"`
+}
+
+// DefaultAuthSecretKey is the key of the authtoken in the Secret referred to by authSecretRef
+const DefaultAuthSecretKey = "authtoken"
+
+// DefaultBasicAuthSecretKey is the key of the htpasswd credentials in the Secret referred to by basicAuth
+const DefaultBasicAuthSecretKey = "auth"
+
+// keys in the Secret referred to by oauth
+const (
+	OAuthClientIDKey     = "client-id"
+	OAuthClientSecretKey = "client-secret"
+	OAuthCookieSecretKey = "cookie-secret"
+)
+
+// SourceReference identifies the workload (or Service) to be exposed
+type SourceReference struct {
+	// kind of the source. a Service is created for workloads (and Pods), while an existing Service is exposed as is
+	//+kubebuilder:validation:Enum=Deployment;StatefulSet;DaemonSet;ReplicaSet;Pod;Service
+	//+kubebuilder:default=Deployment
+	//+optional
+	Kind string `json:"kind,omitempty"`
+
+	// name of the source. it's also used to name the Service and tunnel Deployment
+	//+kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// labels of the Pods to be exposed. only applicable for kind Pod - if not specified, the labels of the Pod with the given name are used
+	//+optional
+	Selector map[string]string `json:"selector,omitempty"`
+}
+
+// PortSpec is a port to be exposed using a tunnel
+type PortSpec struct {
+	// name of the port. must be unique - it's used to name the Service port and the tunnel
+	//+kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	//+kubebuilder:validation:MaxLength=15
+	Name string `json:"name"`
+
+	// port of the Service
+	//+kubebuilder:validation:Minimum=1
+	//+kubebuilder:validation:Maximum=65535
+	Port int32 `json:"port"`
+
+	// port (number or name) of the source Pods. defaults to port
+	//+optional
+	TargetPort *intstr.IntOrString `json:"targetPort,omitempty"`
+
+	// tunnel protocol. defaults to http
+	//+kubebuilder:validation:Enum=http;tcp;tls
+	//+kubebuilder:default=http
+	//+optional
+	Protocol string `json:"protocol,omitempty"`
+
+	// reserved hostname (custom domain) for the port. not applicable to tcp
+	//+optional
+	Hostname string `json:"hostname,omitempty"`
+
+	// reserved subdomain of the provider domain for the port. ngrok only, not applicable to tcp
+	//+optional
+	Subdomain string `json:"subdomain,omitempty"`
+}
+
+// supported tunnel protocols
+const (
+	ProtocolHTTP = "http"
+	ProtocolTCP  = "tcp"
+	ProtocolTLS  = "tls"
+)
+
+// DefaultProvider is the tunnel provider used if provider is not specified
+const DefaultProvider = "ngrok"
+
+// DefaultPortName is the name of the port specified using the port attribute
+const DefaultPortName = "default"
+
+// PortList returns the ports to be exposed, taking into account the port attribute
+func (s *KubexposeSpec) PortList() []PortSpec {
+	if len(s.Ports) == 0 {
+		protocol := s.Protocol
+		if protocol == "" {
+			protocol = ProtocolHTTP
+		}
+		return []PortSpec{{Name: DefaultPortName, Port: int32(s.PortToExpose), Protocol: protocol, Hostname: s.Hostname, Subdomain: s.Subdomain}}
+	}
+
+	ports := make([]PortSpec, len(s.Ports))
+	for i, port := range s.Ports {
+		if port.Protocol == "" {
+			port.Protocol = ProtocolHTTP
+		}
+		ports[i] = port
+	}
+	return ports
+}
+
+// supported source kinds
+const (
+	SourceKindDeployment  = "Deployment"
+	SourceKindStatefulSet = "StatefulSet"
+	SourceKindDaemonSet   = "DaemonSet"
+	SourceKindReplicaSet  = "ReplicaSet"
+	SourceKindPod         = "Pod"
+	SourceKindService     = "Service"
+)
+
+// SourceRef returns the source to be exposed, taking into account the deprecated sourceDeployment attribute
+func (s *KubexposeSpec) SourceRef() SourceReference {
+	if s.Source == nil {
+		return SourceReference{Kind: SourceKindDeployment, Name: s.SourceDeploymentName}
+	}
+
+	ref := *s.Source
+	if ref.Kind == "" {
+		ref.Kind = SourceKindDeployment
+	}
+	return ref
 }
 
 // KubexposeStatus defines the observed state of Kubexpose
 type KubexposeStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
+	// public url of the (first) port
 	PublicURL string `json:"url"`
+
+	// public url of each port
+	//+optional
+	//+listType=map
+	//+listMapKey=name
+	URLs []PortURL `json:"urls,omitempty"`
+
+	// high level summary of where the resource is in its lifecycle
+	//+optional
+	Phase KubexposePhase `json:"phase,omitempty"`
+
+	// source being exposed, as per source or the deprecated sourceDeployment
+	//+optional
+	Source *SourceReference `json:"source,omitempty"`
+
+	// public urls issued for the resource, the latest one first. bounded - the oldest ones are dropped
+	//+optional
+	URLHistory []URLHistoryEntry `json:"urlHistory,omitempty"`
+
+	// when a public url was last assigned or retired
+	//+optional
+	LastURLChange *metav1.Time `json:"lastURLChange,omitempty"`
+
+	// delivery state of the notifications for each target in notify
+	//+optional
+	//+listType=map
+	//+listMapKey=name
+	Notifications []NotificationStatus `json:"notifications,omitempty"`
+
+	// when the public url stops being available, as per ttl and expiresAt
+	//+optional
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+
+	// generation of the resource which was last processed by the operator
+	//+optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// detailed state of the Service, tunnel and public url
+	//+optional
+	//+listType=map
+	//+listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+// PortURL is the public url for a port
+type PortURL struct {
+	// name of the port
+	Name string `json:"name"`
+	// public url of the tunnel for the port
+	URL string `json:"url"`
+}
+
+// URLHistoryEntry is a public url which has been issued for a port
+type URLHistoryEntry struct {
+	// name of the port
+	Port string `json:"port"`
+	// public url of the tunnel for the port
+	URL string `json:"url"`
+	// when the url was discovered
+	AssignedAt metav1.Time `json:"assignedAt"`
+	// when the url stopped being used e.g. the tunnel was restarted or stopped. not set for the urls in use
+	//+optional
+	RetiredAt *metav1.Time `json:"retiredAt,omitempty"`
+	// tunnel Pod which was assigned the url
+	//+optional
+	PodName string `json:"podName,omitempty"`
 }
 
+// NotificationStatus is the delivery state of the notifications for a target
+type NotificationStatus struct {
+	// name of the target
+	Name string `json:"name"`
+	// public url of each port the target has been notified of (or has given up on)
+	//+optional
+	//+listType=map
+	//+listMapKey=name
+	URLs []PortURL `json:"urls,omitempty"`
+	// state of the latest notification
+	//+optional
+	State NotificationState `json:"state,omitempty"`
+	// number of failed attempts to deliver the pending notification
+	//+optional
+	Attempts int32 `json:"attempts,omitempty"`
+	// when a notification was last posted to the target
+	//+optional
+	LastAttemptTime *metav1.Time `json:"lastAttemptTime,omitempty"`
+	// when a notification was last delivered to the target
+	//+optional
+	LastDeliveryTime *metav1.Time `json:"lastDeliveryTime,omitempty"`
+	// error of the last failed attempt
+	//+optional
+	Message string `json:"message,omitempty"`
+}
+
+// NotificationState is the delivery state of a notification
+//+kubebuilder:validation:Enum=Delivered;Retrying;Failed
+type NotificationState string
+
+const (
+	// NotificationDelivered means that the target accepted the notification
+	NotificationDelivered NotificationState = "Delivered"
+	// NotificationRetrying means that the notification could not be delivered (yet) and will be retried
+	NotificationRetrying NotificationState = "Retrying"
+	// NotificationFailed means that the notification could not be delivered and was dropped
+	NotificationFailed NotificationState = "Failed"
+)
+
+// KubexposePhase is a high level summary of the state of a Kubexpose resource
+//+kubebuilder:validation:Enum=Pending;Provisioning;Ready;Failed;Terminating;Expired;Idle
+type KubexposePhase string
+
+const (
+	// PhasePending means that the source is not (yet) available
+	PhasePending KubexposePhase = "Pending"
+	// PhaseProvisioning means that the Service and tunnel are being setup
+	PhaseProvisioning KubexposePhase = "Provisioning"
+	// PhaseReady means that the public url is available
+	PhaseReady KubexposePhase = "Ready"
+	// PhaseFailed means that the resource can not be reconciled without a change in its spec
+	PhaseFailed KubexposePhase = "Failed"
+	// PhaseTerminating means that the tunnel is being torn down before the resource is deleted
+	PhaseTerminating KubexposePhase = "Terminating"
+	// PhaseExpired means that the tunnel has been torn down since the resource expired
+	PhaseExpired KubexposePhase = "Expired"
+	// PhaseIdle means that the tunnel has been scaled down since it's outside the scheduled windows
+	PhaseIdle KubexposePhase = "Idle"
+)
+
+// condition types for Kubexpose
+const (
+	// ConditionSourceFound tells whether the source workload (or Service) exists
+	ConditionSourceFound = "SourceFound"
+	// ConditionServiceReady tells whether the Service for the source exists
+	ConditionServiceReady = "ServiceReady"
+	// ConditionTunnelReady tells whether the tunnel Deployment is running and healthy
+	ConditionTunnelReady = "TunnelReady"
+	// ConditionURLAvailable tells whether the public url has been discovered
+	ConditionURLAvailable = "URLAvailable"
+	// ConditionExpired tells whether the resource has expired. only set if ttl or expiresAt is specified
+	ConditionExpired = "Expired"
+	// ConditionWithinSchedule tells whether one of the scheduled windows is open. only set if schedule is specified
+	ConditionWithinSchedule = "WithinSchedule"
+	// ConditionReservedName tells whether the public urls use the requested hostname or subdomain. only set if there is one
+	ConditionReservedName = "ReservedName"
+	// ConditionPublished tells whether the public url has been written to the ConfigMap or Secret. only set if publishTo is specified
+	ConditionPublished = "Published"
+	// ConditionPolicyCompliant tells whether the resource complies with the KubexposePolicy resources. only set if there are any
+	ConditionPolicyCompliant = "PolicyCompliant"
+)
+
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Kind",type=string,JSONPath=`.status.source.kind`
+//+kubebuilder:printcolumn:name="Source",type=string,JSONPath=`.status.source.name`
+//+kubebuilder:printcolumn:name="Provider",type=string,JSONPath=`.spec.provider`
+//+kubebuilder:printcolumn:name="URL",type=string,JSONPath=`.status.url`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="URLAvailable")].status`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Expires",type=date,JSONPath=`.status.expiresAt`
+//+kubebuilder:printcolumn:name="URL Changed",type=date,JSONPath=`.status.lastURLChange`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // Kubexpose is the Schema for the kubexposes API
 type Kubexpose struct {
@@ -53,6 +594,19 @@ type Kubexpose struct {
 	Status KubexposeStatus `json:"status,omitempty"`
 }
 
+// ExposedNamespace returns the namespace in which the source is looked up and the Service and tunnel Deployment are created
+func (k *Kubexpose) ExposedNamespace() string {
+	if k.Spec.TargetNamespace == "" {
+		return k.Namespace
+	}
+	return k.Spec.TargetNamespace
+}
+
+// IsCrossNamespace tells whether the source is in a namespace other than that of the Kubexpose resource
+func (k *Kubexpose) IsCrossNamespace() bool {
+	return k.ExposedNamespace() != k.Namespace
+}
+
 //+kubebuilder:object:root=true
 
 // KubexposeList contains a list of Kubexpose
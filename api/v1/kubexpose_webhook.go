@@ -0,0 +1,380 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var kubexposelog = logf.Log.WithName("kubexpose-resource")
+
+// used by the validating webhook to look up the target namespace and source. reads go to the API server directly,
+// since there is no need to cache (and watch) all the namespaces
+var webhookClient client.Reader
+
+func (r *Kubexpose) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	webhookClient = mgr.GetAPIReader()
+
+	err := setupTargetNamespaceWebhook(mgr)
+	if err != nil {
+		return err
+	}
+
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-kubexpose-kubexpose-io-v1-kubexpose,mutating=true,failurePolicy=fail,sideEffects=None,groups=kubexpose.kubexpose.io,resources=kubexposes,verbs=create;update,versions=v1,name=mkubexpose.kb.io,admissionReviewVersions={v1,v1beta1}
+
+var _ webhook.Defaulter = &Kubexpose{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (r *Kubexpose) Default() {
+	kubexposelog.Info("default", "name", r.Name)
+
+	if r.Spec.TargetNamespace == "" {
+		r.Spec.TargetNamespace = r.Namespace
+	}
+
+	// the deprecated sourceDeployment is cleared once it's converted, so that it's only set if it was (re)specified in the request -
+	// otherwise a change to it would be ignored since the source takes precedence
+	if r.Spec.SourceDeploymentName != "" {
+		r.Spec.Source = &SourceReference{Kind: SourceKindDeployment, Name: r.Spec.SourceDeploymentName}
+		r.Spec.SourceDeploymentName = ""
+	}
+	if r.Spec.Source != nil && r.Spec.Source.Kind == "" {
+		r.Spec.Source.Kind = SourceKindDeployment
+	}
+
+	if r.Spec.PortToExpose != 0 && r.Spec.Protocol == "" {
+		r.Spec.Protocol = ProtocolHTTP
+	}
+	for i := range r.Spec.Ports {
+		if r.Spec.Ports[i].Protocol == "" {
+			r.Spec.Ports[i].Protocol = ProtocolHTTP
+		}
+	}
+
+	if (r.Spec.TTL != nil || r.Spec.ExpiresAt != nil) && r.Spec.ExpiryAction == "" {
+		r.Spec.ExpiryAction = ExpiryActionTeardown
+	}
+}
+
+// the namespaces and source kinds are looked up by the validating webhook
+//+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get
+
+//+kubebuilder:webhook:path=/validate-kubexpose-kubexpose-io-v1-kubexpose,mutating=false,failurePolicy=fail,sideEffects=None,groups=kubexpose.kubexpose.io,resources=kubexposes,verbs=create;update,versions=v1,name=vkubexpose.kb.io,admissionReviewVersions={v1,v1beta1}
+
+var _ webhook.Validator = &Kubexpose{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *Kubexpose) ValidateCreate() error {
+	kubexposelog.Info("validate create", "name", r.Name)
+
+	allErrs := r.validateSpec()
+	allErrs = append(allErrs, r.validateReferences(context.Background())...)
+	allErrs = append(allErrs, r.validatePolicies(context.Background(), true)...)
+
+	return r.invalid(allErrs)
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *Kubexpose) ValidateUpdate(old runtime.Object) error {
+	kubexposelog.Info("validate update", "name", r.Name)
+
+	// nothing to validate if the resource is going away
+	if !r.DeletionTimestamp.IsZero() {
+		return nil
+	}
+
+	// e.g. the finalizer is added or a label is changed. the resource was valid when it was created, and those which
+	// violate a newer policy are stopped by the controller - rejecting them would only get in the way
+	oldKexp := old.(*Kubexpose)
+	if equality.Semantic.DeepEqual(r.Spec, oldKexp.Spec) {
+		return nil
+	}
+
+	allErrs := r.validateSpec()
+
+	// the source and target namespace may be changed - the reconciler deletes the Service and tunnel Deployment
+	// created for the previous ones
+	if r.ExposedNamespace() != oldKexp.ExposedNamespace() {
+		allErrs = append(allErrs, r.validateReferences(context.Background())...)
+	}
+
+	allErrs = append(allErrs, r.validatePolicies(context.Background(), false)...)
+
+	return r.invalid(allErrs)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *Kubexpose) ValidateDelete() error {
+	kubexposelog.Info("validate delete", "name", r.Name)
+
+	return nil
+}
+
+func (r *Kubexpose) invalid(allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("Kubexpose").GroupKind(), r.Name, allErrs)
+}
+
+// validatePolicies checks the Kubexpose resource against the KubexposePolicy resources. the number of tunnels per namespace
+// is only checked on creation - after that, the reconciler stops the tunnels which are beyond the limit
+func (r *Kubexpose) validatePolicies(ctx context.Context, create bool) field.ErrorList {
+	if webhookClient == nil {
+		return nil
+	}
+
+	var policies KubexposePolicyList
+	if err := webhookClient.List(ctx, &policies); err != nil {
+		return field.ErrorList{field.InternalError(field.NewPath("spec"), err)}
+	}
+	if len(policies.Items) == 0 {
+		return nil
+	}
+
+	var allErrs field.ErrorList
+	now := time.Now()
+	for i := range policies.Items {
+		allErrs = append(allErrs, policies.Items[i].Violations(r, now)...)
+	}
+
+	if !create {
+		return allErrs
+	}
+
+	var kexps KubexposeList
+	if err := webhookClient.List(ctx, &kexps); err != nil {
+		return append(allErrs, field.InternalError(field.NewPath("spec"), err))
+	}
+	running := TunnelsAhead(r, kexps.Items)
+
+	for i := range policies.Items {
+		policy := &policies.Items[i]
+		if policy.TunnelLimitReached(running) {
+			allErrs = append(allErrs, field.Forbidden(field.NewPath("spec", "targetNamespace"), policy.message("namespace %s already has %d running tunnels", r.ExposedNamespace(), running)))
+		}
+	}
+
+	return allErrs
+}
+
+// validateSpec checks the spec for problems which can't be expressed in the CRD schema
+func (r *Kubexpose) validateSpec() field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	if r.Spec.SourceRef().Name == "" {
+		allErrs = append(allErrs, field.Required(specPath.Child("source", "name"), "source is required"))
+	}
+
+	if len(r.Spec.Ports) == 0 {
+		if r.Spec.PortToExpose < 1 || r.Spec.PortToExpose > 65535 {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("port"), r.Spec.PortToExpose, "port must be between 1 and 65535 if ports is not specified"))
+		}
+	} else if r.Spec.PortToExpose != 0 {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("port"), "port and ports are mutually exclusive"))
+	}
+
+	for i, port := range r.Spec.Ports {
+		if port.Port < 1 || port.Port > 65535 {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("ports").Index(i).Child("port"), port.Port, "port must be between 1 and 65535"))
+		}
+	}
+
+	allErrs = append(allErrs, r.validateReservedNames()...)
+	allErrs = append(allErrs, r.validateNotify()...)
+
+	if publishTo := r.Spec.PublishTo; publishTo != nil {
+		publishPath := specPath.Child("publishTo")
+
+		if publishTo.ConfigMap == nil && publishTo.Secret == nil {
+			allErrs = append(allErrs, field.Required(publishPath, "configMap or secret is required"))
+		}
+		if publishTo.ConfigMap != nil {
+			for _, msg := range validation.IsDNS1123Subdomain(publishTo.ConfigMap.Name) {
+				allErrs = append(allErrs, field.Invalid(publishPath.Child("configMap", "name"), publishTo.ConfigMap.Name, msg))
+			}
+		}
+		if publishTo.Secret != nil {
+			for _, msg := range validation.IsDNS1123Subdomain(publishTo.Secret.Name) {
+				allErrs = append(allErrs, field.Invalid(publishPath.Child("secret", "name"), publishTo.Secret.Name, msg))
+			}
+		}
+
+		switch r.Spec.SourceRef().Kind {
+		case SourceKindDeployment, SourceKindStatefulSet, SourceKindDaemonSet:
+		default:
+			if publishTo.RolloutSource {
+				allErrs = append(allErrs, field.Forbidden(publishPath.Child("rolloutSource"), "only a Deployment, StatefulSet or DaemonSet can be rolled out"))
+			}
+		}
+	}
+
+	if r.Spec.TTL != nil && r.Spec.TTL.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("ttl"), r.Spec.TTL.Duration.String(), "ttl must be positive"))
+	}
+
+	if access := r.Spec.Access; access != nil {
+		accessPath := specPath.Child("access")
+
+		for _, port := range r.Spec.PortList() {
+			if port.Protocol != ProtocolHTTP {
+				allErrs = append(allErrs, field.Forbidden(accessPath, fmt.Sprintf("access can't be restricted for protocol %s (port %s)", port.Protocol, port.Name)))
+			}
+		}
+
+		if access.OAuth != nil && access.OAuth.Provider == "oidc" && access.OAuth.IssuerURL == "" {
+			allErrs = append(allErrs, field.Required(accessPath.Child("oauth", "issuerURL"), "issuerURL is required for provider oidc"))
+		}
+
+		for i, cidr := range access.AllowCIDRs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				allErrs = append(allErrs, field.Invalid(accessPath.Child("allowCIDRs").Index(i), cidr, "invalid CIDR"))
+			}
+		}
+		for i, cidr := range access.DenyCIDRs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				allErrs = append(allErrs, field.Invalid(accessPath.Child("denyCIDRs").Index(i), cidr, "invalid CIDR"))
+			}
+		}
+	}
+
+	if r.Spec.Schedule != nil {
+		if _, _, err := r.Spec.Schedule.WindowState(time.Now()); err != nil {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("schedule"), r.Spec.Schedule, err.Error()))
+		}
+	}
+
+	return allErrs
+}
+
+// validateReservedNames checks the hostname and subdomain of each port
+func (r *Kubexpose) validateReservedNames() field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	if len(r.Spec.Ports) > 0 {
+		if r.Spec.Hostname != "" {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("hostname"), "use ports[].hostname with ports"))
+		}
+		if r.Spec.Subdomain != "" {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("subdomain"), "use ports[].subdomain with ports"))
+		}
+	}
+
+	hostnames := map[string]bool{}
+	for i, port := range r.Spec.PortList() {
+		portPath := specPath
+		if len(r.Spec.Ports) > 0 {
+			portPath = specPath.Child("ports").Index(i)
+		}
+
+		if port.Hostname == "" && port.Subdomain == "" {
+			continue
+		}
+		if port.Hostname != "" && port.Subdomain != "" {
+			allErrs = append(allErrs, field.Forbidden(portPath.Child("subdomain"), "hostname and subdomain are mutually exclusive"))
+		}
+		if port.Protocol == ProtocolTCP {
+			allErrs = append(allErrs, field.Forbidden(portPath.Child("hostname"), "tcp tunnels can't have a hostname or subdomain"))
+		}
+
+		if port.Hostname != "" {
+			for _, msg := range validation.IsDNS1123Subdomain(port.Hostname) {
+				allErrs = append(allErrs, field.Invalid(portPath.Child("hostname"), port.Hostname, msg))
+			}
+			if hostnames[port.Hostname] {
+				allErrs = append(allErrs, field.Duplicate(portPath.Child("hostname"), port.Hostname))
+			}
+			hostnames[port.Hostname] = true
+		}
+		if port.Subdomain != "" {
+			for _, msg := range validation.IsDNS1123Label(port.Subdomain) {
+				allErrs = append(allErrs, field.Invalid(portPath.Child("subdomain"), port.Subdomain, msg))
+			}
+		}
+	}
+
+	return allErrs
+}
+
+// validateNotify checks the url and template of each notify target
+func (r *Kubexpose) validateNotify() field.ErrorList {
+	var allErrs field.ErrorList
+
+	for i, target := range r.Spec.Notify {
+		targetPath := field.NewPath("spec", "notify").Index(i)
+
+		u, err := url.Parse(target.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			allErrs = append(allErrs, field.Invalid(targetPath.Child("url"), target.URL, "must be an absolute http or https url"))
+		}
+
+		if target.Template != "" {
+			if _, err := ParseNotifyTemplate(target.Name, target.Template); err != nil {
+				allErrs = append(allErrs, field.Invalid(targetPath.Child("template"), target.Template, err.Error()))
+			}
+		}
+	}
+
+	return allErrs
+}
+
+// validateReferences makes sure that the target namespace exists. the source might be created later (e.g. along with the
+// Kubexpose resource) - it's watched and reported by the SourceFound condition
+func (r *Kubexpose) validateReferences(ctx context.Context) field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	if webhookClient == nil {
+		return nil
+	}
+
+	namespace := r.ExposedNamespace()
+
+	err := webhookClient.Get(ctx, types.NamespacedName{Name: namespace}, &corev1.Namespace{})
+	if apierrors.IsNotFound(err) {
+		return append(allErrs, field.NotFound(specPath.Child("targetNamespace"), namespace))
+	}
+	if err != nil {
+		return append(allErrs, field.InternalError(specPath.Child("targetNamespace"), err))
+	}
+
+	return allErrs
+}
@@ -0,0 +1,294 @@
+package v1
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("Kubexpose webhook", func() {
+	const namespace = "default"
+
+	newKubexpose := func(name string, spec KubexposeSpec) *Kubexpose {
+		return &Kubexpose{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       spec,
+		}
+	}
+
+	BeforeEach(func() {
+		labels := map[string]string{"app": "nginx"}
+		deployment := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: namespace},
+			Spec: appsv1.DeploymentSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: labels},
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: labels},
+					Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "nginx", Image: "nginx"}}},
+				},
+			},
+		}
+		err := k8sClient.Create(ctx, deployment)
+		if !apierrors.IsAlreadyExists(err) {
+			Expect(err).NotTo(HaveOccurred())
+		}
+	})
+
+	Context("defaulting", func() {
+		It("defaults the target namespace and protocol", func() {
+			kexp := newKubexpose("defaults", KubexposeSpec{Source: &SourceReference{Name: "nginx"}, PortToExpose: 80})
+			Expect(k8sClient.Create(ctx, kexp)).To(Succeed())
+
+			var created Kubexpose
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "defaults"}, &created)).To(Succeed())
+			Expect(created.Spec.TargetNamespace).To(Equal(namespace))
+			Expect(created.Spec.Protocol).To(Equal(ProtocolHTTP))
+			Expect(created.Spec.Source.Kind).To(Equal(SourceKindDeployment))
+
+			Expect(k8sClient.Delete(ctx, &created)).To(Succeed())
+		})
+
+		It("converts sourceDeployment to source", func() {
+			kexp := newKubexpose("deprecated", KubexposeSpec{SourceDeploymentName: "nginx", PortToExpose: 80})
+			Expect(k8sClient.Create(ctx, kexp)).To(Succeed())
+
+			var created Kubexpose
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "deprecated"}, &created)).To(Succeed())
+			Expect(created.Spec.Source).NotTo(BeNil())
+			Expect(created.Spec.Source.Name).To(Equal("nginx"))
+			Expect(created.Spec.SourceDeploymentName).To(BeEmpty())
+
+			Expect(k8sClient.Delete(ctx, &created)).To(Succeed())
+		})
+
+		It("converts an updated sourceDeployment to source", func() {
+			kexp := newKubexpose("deprecated-update", KubexposeSpec{SourceDeploymentName: "nginx", PortToExpose: 80})
+			Expect(k8sClient.Create(ctx, kexp)).To(Succeed())
+
+			kexp.Spec.SourceDeploymentName = "another"
+			Expect(k8sClient.Update(ctx, kexp)).To(Succeed())
+
+			var updated Kubexpose
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "deprecated-update"}, &updated)).To(Succeed())
+			Expect(updated.Spec.Source).NotTo(BeNil())
+			Expect(updated.Spec.Source.Kind).To(Equal(SourceKindDeployment))
+			Expect(updated.Spec.Source.Name).To(Equal("another"))
+			Expect(updated.Spec.SourceDeploymentName).To(BeEmpty())
+
+			Expect(k8sClient.Delete(ctx, &updated)).To(Succeed())
+		})
+	})
+
+	Context("validation", func() {
+		It("rejects a missing port", func() {
+			kexp := newKubexpose("no-port", KubexposeSpec{Source: &SourceReference{Name: "nginx"}})
+			err := k8sClient.Create(ctx, kexp)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "unexpected error %v", err)
+		})
+
+		It("rejects a port out of range", func() {
+			kexp := newKubexpose("big-port", KubexposeSpec{Source: &SourceReference{Name: "nginx"}, PortToExpose: 70000})
+			err := k8sClient.Create(ctx, kexp)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "unexpected error %v", err)
+		})
+
+		It("rejects port and ports together", func() {
+			kexp := newKubexpose("both-ports", KubexposeSpec{
+				Source:       &SourceReference{Name: "nginx"},
+				PortToExpose: 80,
+				Ports:        []PortSpec{{Name: "web", Port: 8080}},
+			})
+			err := k8sClient.Create(ctx, kexp)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "unexpected error %v", err)
+		})
+
+		It("rejects a missing source", func() {
+			kexp := newKubexpose("no-source", KubexposeSpec{PortToExpose: 80})
+			err := k8sClient.Create(ctx, kexp)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "unexpected error %v", err)
+		})
+
+		It("accepts a source which does not exist yet", func() {
+			kexp := newKubexpose("missing-source", KubexposeSpec{Source: &SourceReference{Name: "does-not-exist"}, PortToExpose: 80})
+			Expect(k8sClient.Create(ctx, kexp)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, kexp)).To(Succeed())
+		})
+
+		It("rejects a target namespace which does not exist", func() {
+			kexp := newKubexpose("missing-namespace", KubexposeSpec{Source: &SourceReference{Name: "nginx"}, PortToExpose: 80, TargetNamespace: "does-not-exist"})
+			err := k8sClient.Create(ctx, kexp)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "unexpected error %v", err)
+		})
+
+		It("rejects access restrictions for tcp ports", func() {
+			kexp := newKubexpose("tcp-access", KubexposeSpec{
+				Source:       &SourceReference{Name: "nginx"},
+				PortToExpose: 6379,
+				Protocol:     ProtocolTCP,
+				Access:       &AccessSpec{AllowCIDRs: []string{"10.0.0.0/8"}},
+			})
+			err := k8sClient.Create(ctx, kexp)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "unexpected error %v", err)
+		})
+
+		It("rejects an invalid CIDR", func() {
+			kexp := newKubexpose("bad-cidr", KubexposeSpec{
+				Source:       &SourceReference{Name: "nginx"},
+				PortToExpose: 80,
+				Access:       &AccessSpec{DenyCIDRs: []string{"10.0.0.0/33"}},
+			})
+			err := k8sClient.Create(ctx, kexp)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "unexpected error %v", err)
+		})
+
+		It("rejects an invalid schedule", func() {
+			kexp := newKubexpose("bad-schedule", KubexposeSpec{
+				Source:       &SourceReference{Name: "nginx"},
+				PortToExpose: 80,
+				Schedule:     &ScheduleSpec{TimeZone: "Mars/Olympus", Windows: []ScheduleWindow{{Cron: "0 9 * * 1-5", Duration: metav1.Duration{Duration: 9 * time.Hour}}}},
+			})
+			err := k8sClient.Create(ctx, kexp)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "unexpected error %v", err)
+		})
+
+		It("rejects an invalid notify url", func() {
+			kexp := newKubexpose("bad-notify-url", KubexposeSpec{
+				Source:       &SourceReference{Name: "nginx"},
+				PortToExpose: 80,
+				Notify:       []NotifyTarget{{Name: "hook", URL: "ftp://example.com/hook"}},
+			})
+			err := k8sClient.Create(ctx, kexp)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "unexpected error %v", err)
+		})
+
+		It("rejects an invalid notify template", func() {
+			kexp := newKubexpose("bad-notify-template", KubexposeSpec{
+				Source:       &SourceReference{Name: "nginx"},
+				PortToExpose: 80,
+				Notify:       []NotifyTarget{{Name: "hook", URL: "https://example.com/hook", Template: `{"url": {{ .URL | quote }}}`}},
+			})
+			err := k8sClient.Create(ctx, kexp)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "unexpected error %v", err)
+		})
+
+		It("rejects rolling out a Service", func() {
+			kexp := newKubexpose("bad-rollout", KubexposeSpec{
+				Source:       &SourceReference{Kind: SourceKindService, Name: "nginx"},
+				PortToExpose: 80,
+				PublishTo:    &PublishSpec{ConfigMap: &PublishTarget{Name: "nginx-url"}, RolloutSource: true},
+			})
+			err := k8sClient.Create(ctx, kexp)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "unexpected error %v", err)
+		})
+
+		It("allows changes to the source", func() {
+			kexp := newKubexpose("change-source", KubexposeSpec{Source: &SourceReference{Name: "nginx"}, PortToExpose: 80})
+			Expect(k8sClient.Create(ctx, kexp)).To(Succeed())
+
+			kexp.Spec.Source.Name = "another"
+			Expect(k8sClient.Update(ctx, kexp)).To(Succeed())
+
+			Expect(k8sClient.Delete(ctx, kexp)).To(Succeed())
+		})
+
+		It("rejects a change to a target namespace which does not exist", func() {
+			kexp := newKubexpose("change-namespace", KubexposeSpec{Source: &SourceReference{Name: "nginx"}, PortToExpose: 80})
+			Expect(k8sClient.Create(ctx, kexp)).To(Succeed())
+
+			kexp.Spec.TargetNamespace = "does-not-exist"
+			err := k8sClient.Update(ctx, kexp)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "unexpected error %v", err)
+
+			Expect(k8sClient.Delete(ctx, kexp)).To(Succeed())
+		})
+	})
+
+	Context("policies", func() {
+		var policy *KubexposePolicy
+
+		BeforeEach(func() {
+			maxTunnels := int32(1)
+			policy = &KubexposePolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "restricted"},
+				Spec: KubexposePolicySpec{
+					AllowedPorts:           []PortRange{{From: 80}, {From: 8000, To: 8999}},
+					MaxTTL:                 &metav1.Duration{Duration: time.Hour},
+					MaxTunnelsPerNamespace: &maxTunnels,
+				},
+			}
+			Expect(k8sClient.Create(ctx, policy)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(k8sClient.Delete(ctx, policy)).To(Succeed())
+		})
+
+		It("rejects a port which is not allowed", func() {
+			kexp := newKubexpose("policy-port", KubexposeSpec{
+				Source:       &SourceReference{Name: "nginx"},
+				PortToExpose: 443,
+				TTL:          &metav1.Duration{Duration: time.Minute},
+			})
+			err := k8sClient.Create(ctx, kexp)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "unexpected error %v", err)
+		})
+
+		It("requires a ttl within the limit", func() {
+			kexp := newKubexpose("policy-no-ttl", KubexposeSpec{Source: &SourceReference{Name: "nginx"}, PortToExpose: 80})
+			err := k8sClient.Create(ctx, kexp)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "unexpected error %v", err)
+
+			kexp = newKubexpose("policy-long-ttl", KubexposeSpec{
+				Source:       &SourceReference{Name: "nginx"},
+				PortToExpose: 80,
+				TTL:          &metav1.Duration{Duration: 2 * time.Hour},
+			})
+			err = k8sClient.Create(ctx, kexp)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "unexpected error %v", err)
+		})
+
+		It("rejects tunnels beyond the limit for the namespace", func() {
+			first := newKubexpose("policy-first", KubexposeSpec{
+				Source:       &SourceReference{Name: "nginx"},
+				PortToExpose: 8080,
+				TTL:          &metav1.Duration{Duration: time.Minute},
+			})
+			Expect(k8sClient.Create(ctx, first)).To(Succeed())
+
+			second := newKubexpose("policy-second", KubexposeSpec{
+				Source:       &SourceReference{Name: "nginx"},
+				PortToExpose: 8080,
+				TTL:          &metav1.Duration{Duration: time.Minute},
+			})
+			err := k8sClient.Create(ctx, second)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "unexpected error %v", err)
+
+			Expect(k8sClient.Delete(ctx, first)).To(Succeed())
+		})
+
+		It("allows metadata updates to resources which predate the policy", func() {
+			Expect(k8sClient.Delete(ctx, policy)).To(Succeed())
+			kexp := newKubexpose("policy-existing", KubexposeSpec{Source: &SourceReference{Name: "nginx"}, PortToExpose: 443})
+			Expect(k8sClient.Create(ctx, kexp)).To(Succeed())
+
+			policy.ResourceVersion = ""
+			Expect(k8sClient.Create(ctx, policy)).To(Succeed())
+
+			kexp.Labels = map[string]string{"team": "web"}
+			Expect(k8sClient.Update(ctx, kexp)).To(Succeed())
+
+			kexp.Spec.PortToExpose = 8443
+			err := k8sClient.Update(ctx, kexp)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "unexpected error %v", err)
+
+			Expect(k8sClient.Delete(ctx, kexp)).To(Succeed())
+		})
+	})
+})
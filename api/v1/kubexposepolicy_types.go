@@ -0,0 +1,93 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// KubexposePolicySpec defines what may be exposed using Kubexpose resources.
+// all the policies in the cluster apply - a Kubexpose resource has to comply with each one of them
+type KubexposePolicySpec struct {
+	// namespaces in which Kubexpose resources may be created, and which they may target. shell patterns such as team-* are supported.
+	// all namespaces are allowed if empty
+	//+optional
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+
+	// tunnel providers which may be used. all providers are allowed if empty
+	//+optional
+	AllowedProviders []string `json:"allowedProviders,omitempty"`
+
+	// ports which may be exposed. all ports are allowed if empty
+	//+optional
+	AllowedPorts []PortRange `json:"allowedPorts,omitempty"`
+
+	// whether the public url has to be protected using access.basicAuth or access.oauth
+	//+optional
+	RequireAuth bool `json:"requireAuth,omitempty"`
+
+	// maximum time for which a public url may be available. Kubexpose resources have to specify a ttl (or expiresAt) within this limit
+	//+optional
+	MaxTTL *metav1.Duration `json:"maxTTL,omitempty"`
+
+	// maximum number of tunnels which may run in a namespace at the same time. the oldest Kubexpose resources get to run theirs
+	//+kubebuilder:validation:Minimum=0
+	//+optional
+	MaxTunnelsPerNamespace *int32 `json:"maxTunnelsPerNamespace,omitempty"`
+}
+
+// PortRange is a range of ports
+type PortRange struct {
+	// first port of the range
+	//+kubebuilder:validation:Minimum=1
+	//+kubebuilder:validation:Maximum=65535
+	From int `json:"from"`
+
+	// last port of the range. defaults to from i.e. a single port
+	//+kubebuilder:validation:Minimum=1
+	//+kubebuilder:validation:Maximum=65535
+	//+optional
+	To int `json:"to,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Require Auth",type=boolean,JSONPath=`.spec.requireAuth`
+//+kubebuilder:printcolumn:name="Max TTL",type=string,JSONPath=`.spec.maxTTL`
+//+kubebuilder:printcolumn:name="Max Tunnels",type=integer,JSONPath=`.spec.maxTunnelsPerNamespace`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// KubexposePolicy is the Schema for the kubexposepolicies API
+type KubexposePolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec KubexposePolicySpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// KubexposePolicyList contains a list of KubexposePolicy
+type KubexposePolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []KubexposePolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&KubexposePolicy{}, &KubexposePolicyList{})
+}
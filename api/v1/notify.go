@@ -0,0 +1,20 @@
+package v1
+
+import (
+	"encoding/json"
+	"text/template"
+)
+
+// functions available in notify templates, in addition to the text/template builtins
+var notifyTemplateFuncs = template.FuncMap{
+	// json quotes a value so that it can be embedded in the JSON request body
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// ParseNotifyTemplate parses the template for the request body of a notify target
+func ParseNotifyTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(notifyTemplateFuncs).Option("missingkey=error").Parse(text)
+}
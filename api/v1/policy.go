@@ -0,0 +1,166 @@
+package v1
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// Violations returns the ways in which the Kubexpose resource does not comply with the policy.
+// now is used in place of the creation time of Kubexpose resources which are yet to be created.
+// the number of tunnels per namespace is not checked - see TunnelLimitReached
+func (p *KubexposePolicy) Violations(kexp *Kubexpose, now time.Time) field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	if !p.allowsNamespace(kexp.Namespace) {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("metadata", "namespace"), p.message("namespace %s is not allowed", kexp.Namespace)))
+	}
+	if kexp.IsCrossNamespace() && !p.allowsNamespace(kexp.ExposedNamespace()) {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("targetNamespace"), p.message("namespace %s is not allowed", kexp.ExposedNamespace())))
+	}
+
+	provider := kexp.Spec.Provider
+	if provider == "" {
+		provider = DefaultProvider
+	}
+	if len(p.Spec.AllowedProviders) > 0 && !contains(p.Spec.AllowedProviders, provider) {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("provider"), p.message("provider %s is not allowed", provider)))
+	}
+
+	for i, port := range kexp.Spec.PortList() {
+		if p.allowsPort(int(port.Port)) {
+			continue
+		}
+		portPath := specPath.Child("port")
+		if len(kexp.Spec.Ports) > 0 {
+			portPath = specPath.Child("ports").Index(i).Child("port")
+		}
+		allErrs = append(allErrs, field.Forbidden(portPath, p.message("port %d is not allowed", port.Port)))
+	}
+
+	if p.Spec.RequireAuth {
+		access := kexp.Spec.Access
+		if access == nil || (access.BasicAuth == nil && access.OAuth == nil) {
+			allErrs = append(allErrs, field.Required(specPath.Child("access"), p.message("basicAuth or oauth is required")))
+		}
+	}
+
+	if p.Spec.MaxTTL != nil {
+		created := kexp.CreationTimestamp.Time
+		if created.IsZero() {
+			created = now
+		}
+
+		// same as Expiry, for Kubexpose resources which are yet to be created
+		var expiry *time.Time
+		if kexp.Spec.TTL != nil {
+			ttlExpiry := created.Add(kexp.Spec.TTL.Duration)
+			expiry = &ttlExpiry
+		}
+		if kexp.Spec.ExpiresAt != nil && (expiry == nil || kexp.Spec.ExpiresAt.Time.Before(*expiry)) {
+			expiry = &kexp.Spec.ExpiresAt.Time
+		}
+
+		if expiry == nil {
+			allErrs = append(allErrs, field.Required(specPath.Child("ttl"), p.message("ttl or expiresAt is required (at most %s)", p.Spec.MaxTTL.Duration)))
+		} else if expiry.After(created.Add(p.Spec.MaxTTL.Duration)) {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("ttl"), p.message("public url may be available for at most %s", p.Spec.MaxTTL.Duration)))
+		}
+	}
+
+	return allErrs
+}
+
+// TunnelLimitReached tells whether another tunnel can be run in a namespace which already has the given number of running tunnels
+func (p *KubexposePolicy) TunnelLimitReached(running int) bool {
+	return p.Spec.MaxTunnelsPerNamespace != nil && running >= int(*p.Spec.MaxTunnelsPerNamespace)
+}
+
+func (p *KubexposePolicy) message(format string, args ...interface{}) string {
+	return fmt.Sprintf("policy %s: ", p.Name) + fmt.Sprintf(format, args...)
+}
+
+func (p *KubexposePolicy) allowsNamespace(namespace string) bool {
+	if len(p.Spec.AllowedNamespaces) == 0 {
+		return true
+	}
+	for _, pattern := range p.Spec.AllowedNamespaces {
+		// an invalid pattern does not match anything
+		if ok, _ := path.Match(pattern, namespace); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *KubexposePolicy) allowsPort(port int) bool {
+	if len(p.Spec.AllowedPorts) == 0 {
+		return true
+	}
+	for _, r := range p.Spec.AllowedPorts {
+		to := r.To
+		if to == 0 {
+			to = r.From
+		}
+		if port >= r.From && port <= to {
+			return true
+		}
+	}
+	return false
+}
+
+// TunnelsAhead returns the number of running tunnels in the target namespace of the Kubexpose resource which take precedence over its own.
+// older Kubexpose resources take precedence, hence a Kubexpose resource which is yet to be created comes after all the others
+func TunnelsAhead(kexp *Kubexpose, kexps []Kubexpose) int {
+	count := 0
+	for i := range kexps {
+		other := &kexps[i]
+		if other.UID == kexp.UID && kexp.UID != "" {
+			continue
+		}
+		if other.ExposedNamespace() != kexp.ExposedNamespace() || !other.RunsTunnel() {
+			continue
+		}
+		if !kexp.CreationTimestamp.IsZero() && !isOlder(other, kexp) {
+			continue
+		}
+		count++
+	}
+	return count
+}
+
+func isOlder(a, b *Kubexpose) bool {
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+	// the creation time only has a resolution of one second
+	if a.Namespace != b.Namespace {
+		return a.Namespace < b.Namespace
+	}
+	return a.Name < b.Name
+}
+
+// RunsTunnel tells whether the tunnel of the Kubexpose resource is supposed to be running, as per its status
+func (k *Kubexpose) RunsTunnel() bool {
+	if !k.DeletionTimestamp.IsZero() {
+		return false
+	}
+	switch k.Status.Phase {
+	case PhaseFailed, PhaseExpired, PhaseIdle, PhaseTerminating:
+		return false
+	}
+	return !meta.IsStatusConditionFalse(k.Status.Conditions, ConditionPolicyCompliant)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
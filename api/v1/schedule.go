@@ -0,0 +1,77 @@
+package v1
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// maxActivations limits how many consecutive activations are looked at to find out when a window closes. a window which
+// is open for longer than its cron schedule repeats is extended over and over, it is then checked again after these
+const maxActivations = 10000
+
+// WindowState tells whether any of the windows is open at the given time, and when that's going to change
+func (schedule *ScheduleSpec) WindowState(now time.Time) (bool, time.Time, error) {
+	loc := time.UTC
+	if schedule.TimeZone != "" {
+		var err error
+		loc, err = time.LoadLocation(schedule.TimeZone)
+		if err != nil {
+			return false, time.Time{}, fmt.Errorf("invalid time zone %s: %v", schedule.TimeZone, err)
+		}
+	}
+	// the cron expressions are evaluated in the time zone of the given time
+	now = now.In(loc)
+
+	open := false
+	var next time.Time
+
+	for _, w := range schedule.Windows {
+		start, end, err := w.current(now)
+		if err != nil {
+			return false, time.Time{}, err
+		}
+
+		if !start.After(now) {
+			// overlapping windows are checked again once the one which closes last closes
+			if !open || end.After(next) {
+				next = end
+			}
+			open = true
+			continue
+		}
+
+		if !open && (next.IsZero() || start.Before(next)) {
+			next = start
+		}
+	}
+
+	return open, next, nil
+}
+
+// current returns the window which is open at the given time, or the next one if it's closed
+func (w ScheduleWindow) current(now time.Time) (time.Time, time.Time, error) {
+	sched, err := cron.ParseStandard(w.Cron)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid cron expression %s: %v", w.Cron, err)
+	}
+	if w.Duration.Duration <= 0 {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid duration %s for %s, it must be positive", w.Duration.Duration, w.Cron)
+	}
+
+	// the first activation which is still open, if any, is within the duration before now
+	start := sched.Next(now.Add(-w.Duration.Duration))
+	if start.IsZero() {
+		return time.Time{}, time.Time{}, fmt.Errorf("cron expression %s never matches", w.Cron)
+	}
+
+	// activations before the window closes extend it
+	end := start.Add(w.Duration.Duration)
+	for i, activation := 0, sched.Next(start); i < maxActivations && !activation.IsZero() && !activation.After(end); i++ {
+		end = activation.Add(w.Duration.Duration)
+		activation = sched.Next(activation)
+	}
+
+	return start, end, nil
+}
@@ -0,0 +1,69 @@
+package v1
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestWindowState(t *testing.T) {
+	// 7 June 2021 is a Monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2021, time.June, day, hour, minute, 0, 0, time.UTC)
+	}
+	window := func(cron string, duration time.Duration) ScheduleWindow {
+		return ScheduleWindow{Cron: cron, Duration: metav1.Duration{Duration: duration}}
+	}
+	overnight := []ScheduleWindow{window("0 22 * * Mon-Fri", 8*time.Hour)}
+	weekend := []ScheduleWindow{window("0 10 * * Sat,Sun", 8*time.Hour)}
+
+	tests := []struct {
+		name     string
+		schedule ScheduleSpec
+		now      time.Time
+		open     bool
+		next     time.Time
+		invalid  bool
+	}{
+		{"overnight window open", ScheduleSpec{Windows: overnight}, at(8, 23, 0), true, at(9, 6, 0), false},
+		{"overnight window closed", ScheduleSpec{Windows: overnight}, at(8, 12, 0), false, at(8, 22, 0), false},
+		{"window opened yesterday", ScheduleSpec{Windows: overnight}, at(9, 2, 0), true, at(9, 6, 0), false},
+		{"window opened on friday", ScheduleSpec{Windows: overnight}, at(12, 2, 0), true, at(12, 6, 0), false},
+		{"window closed for the weekend", ScheduleSpec{Windows: overnight}, at(12, 6, 0), false, at(14, 22, 0), false},
+		{"weekend before", ScheduleSpec{Windows: weekend}, at(11, 12, 0), false, at(12, 10, 0), false},
+		{"weekend open", ScheduleSpec{Windows: weekend}, at(13, 17, 0), true, at(13, 18, 0), false},
+		{"weekend after", ScheduleSpec{Windows: weekend}, at(13, 18, 0), false, at(19, 10, 0), false},
+		{
+			"overlapping windows",
+			ScheduleSpec{Windows: []ScheduleWindow{window("0 9 * * *", 3*time.Hour), window("0 11 * * *", 3*time.Hour)}},
+			at(7, 11, 30), true, at(7, 14, 0), false,
+		},
+		{"consecutive activations", ScheduleSpec{Windows: []ScheduleWindow{window("0 9-17 * * 1-5", time.Hour)}}, at(7, 12, 30), true, at(7, 18, 0), false},
+		{"descriptor", ScheduleSpec{Windows: []ScheduleWindow{window("@daily", 2*time.Hour)}}, at(7, 3, 0), false, at(8, 0, 0), false},
+		{"non-UTC zone open", ScheduleSpec{TimeZone: "Europe/Berlin", Windows: []ScheduleWindow{window("0 9 * * 1-5", 8*time.Hour)}}, at(7, 7, 30), true, at(7, 15, 0), false},
+		{"non-UTC zone closed", ScheduleSpec{TimeZone: "Europe/Berlin", Windows: []ScheduleWindow{window("0 9 * * 1-5", 8*time.Hour)}}, at(7, 6, 30), false, at(7, 7, 0), false},
+		{"invalid cron expression", ScheduleSpec{Windows: []ScheduleWindow{window("0 9 * * Fri-Mon-Tue", time.Hour)}}, at(7, 12, 0), false, time.Time{}, true},
+		{"never matches", ScheduleSpec{Windows: []ScheduleWindow{window("0 9 30 2 *", time.Hour)}}, at(7, 12, 0), false, time.Time{}, true},
+		{"invalid duration", ScheduleSpec{Windows: []ScheduleWindow{window("0 9 * * *", 0)}}, at(7, 12, 0), false, time.Time{}, true},
+		{"invalid time zone", ScheduleSpec{TimeZone: "Mars/Olympus", Windows: overnight}, at(7, 12, 0), false, time.Time{}, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			open, next, err := tc.schedule.WindowState(tc.now)
+			if tc.invalid {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if open != tc.open || !next.Equal(tc.next) {
+				t.Errorf("WindowState(%s) = %v, %s, want %v, %s", tc.now, open, next.UTC(), tc.open, tc.next)
+			}
+		})
+	}
+}
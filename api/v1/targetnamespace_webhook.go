@@ -0,0 +1,159 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const targetNamespaceWebhookPath = "/validate-kubexpose-kubexpose-io-v1-kubexpose-target-namespace"
+
+// the operator creates the Service and tunnel Deployment in the target namespace on behalf of the user. without this check,
+// anyone who can create Kubexpose resources could expose workloads in namespaces they have no access to
+var targetNamespaceAccess = []authorizationv1.ResourceAttributes{
+	{Verb: "create", Group: "", Resource: "services"},
+	{Verb: "create", Group: "apps", Resource: "deployments"},
+}
+
+//+kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+//+kubebuilder:webhook:path=/validate-kubexpose-kubexpose-io-v1-kubexpose-target-namespace,mutating=false,failurePolicy=fail,sideEffects=None,groups=kubexpose.kubexpose.io,resources=kubexposes,verbs=create;update,versions=v1,name=vkubexpose-targetnamespace.kb.io,admissionReviewVersions={v1,v1beta1}
+
+// targetNamespaceValidator makes sure that the user who creates a Kubexpose resource is allowed to create Services and Deployments
+// in its target namespace, and to read the Secrets whose contents the operator sends to the notify targets. unlike the
+// webhook.Validator, it has access to the admission request (and the user info in it)
+type targetNamespaceValidator struct {
+	client  client.Client
+	decoder *admission.Decoder
+}
+
+func setupTargetNamespaceWebhook(mgr ctrl.Manager) error {
+	decoder, err := admission.NewDecoder(mgr.GetScheme())
+	if err != nil {
+		return err
+	}
+
+	mgr.GetWebhookServer().Register(targetNamespaceWebhookPath, &webhook.Admission{Handler: &targetNamespaceValidator{client: mgr.GetClient(), decoder: decoder}})
+	return nil
+}
+
+// Handle implements admission.Handler. the access to the target namespace is only checked for Kubexpose resources in a different
+// namespace than the source, the access to the Secrets with the notification headers is always checked
+func (v *targetNamespaceValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	kexp := &Kubexpose{}
+	err := v.decoder.Decode(req, kexp)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	if !kexp.DeletionTimestamp.IsZero() {
+		return admission.Allowed("")
+	}
+
+	// it's checked when the resource is created, or updated to a different target namespace or Secrets
+	var oldKexp *Kubexpose
+	if req.Operation == admissionv1.Update {
+		oldKexp = &Kubexpose{}
+		err = v.decoder.DecodeRaw(req.OldObject, oldKexp)
+		if err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	}
+
+	var required []authorizationv1.ResourceAttributes
+
+	namespace := kexp.ExposedNamespace()
+	if kexp.IsCrossNamespace() && (oldKexp == nil || oldKexp.ExposedNamespace() != namespace) {
+		for _, attributes := range targetNamespaceAccess {
+			attributes.Namespace = namespace
+			required = append(required, attributes)
+		}
+	}
+
+	previous := map[string]bool{}
+	if oldKexp != nil {
+		for _, name := range oldKexp.headersSecrets() {
+			previous[oldKexp.ExposedNamespace()+"/"+name] = true
+		}
+	}
+	for _, name := range kexp.headersSecrets() {
+		if !previous[namespace+"/"+name] {
+			required = append(required, authorizationv1.ResourceAttributes{Verb: "get", Group: "", Resource: "secrets", Namespace: namespace, Name: name})
+		}
+	}
+
+	for _, attributes := range required {
+		allowed, err := v.canAccess(ctx, req, attributes)
+		if err != nil {
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+		if !allowed {
+			resource := attributes.Resource
+			if attributes.Name != "" {
+				resource += " " + attributes.Name
+			}
+			kubexposelog.Info("access to the target namespace not allowed", "name", kexp.Name, "user", req.UserInfo.Username, "targetNamespace", namespace, "verb", attributes.Verb, "resource", resource)
+			return admission.Denied(fmt.Sprintf("%s is not allowed to %s %s in the target namespace %s", req.UserInfo.Username, attributes.Verb, resource, namespace))
+		}
+	}
+
+	return admission.Allowed("")
+}
+
+// headersSecrets returns the names of the Secrets with the HTTP headers of the notify targets
+func (r *Kubexpose) headersSecrets() []string {
+	var names []string
+	for _, target := range r.Spec.Notify {
+		if target.HeadersSecretName != "" {
+			names = append(names, target.HeadersSecretName)
+		}
+	}
+	return names
+}
+
+// canAccess tells whether the user who sent the admission request is authorized as per the given attributes
+func (v *targetNamespaceValidator) canAccess(ctx context.Context, req admission.Request, attributes authorizationv1.ResourceAttributes) (bool, error) {
+	extra := map[string]authorizationv1.ExtraValue{}
+	for key, values := range req.UserInfo.Extra {
+		extra[key] = authorizationv1.ExtraValue(values)
+	}
+
+	sar := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			ResourceAttributes: &attributes,
+			User:               req.UserInfo.Username,
+			Groups:             req.UserInfo.Groups,
+			UID:                req.UserInfo.UID,
+			Extra:              extra,
+		},
+	}
+
+	err := v.client.Create(ctx, sar)
+	if err != nil {
+		return false, err
+	}
+	return sar.Status.Allowed, nil
+}
@@ -0,0 +1,129 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// reviewingClient answers the SubjectAccessReviews with the access of the user
+type reviewingClient struct {
+	client.Client
+	allowed  map[authorizationv1.ResourceAttributes]bool
+	reviewed []authorizationv1.ResourceAttributes
+}
+
+func (c *reviewingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	sar, ok := obj.(*authorizationv1.SubjectAccessReview)
+	if !ok {
+		return c.Client.Create(ctx, obj, opts...)
+	}
+	c.reviewed = append(c.reviewed, *sar.Spec.ResourceAttributes)
+	sar.Status.Allowed = c.allowed[*sar.Spec.ResourceAttributes]
+	return nil
+}
+
+func TestTargetNamespaceValidator(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	decoder, err := admission.NewDecoder(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newKubexpose := func(targetNamespace string, headersSecrets ...string) *Kubexpose {
+		kexp := &Kubexpose{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"},
+			Spec:       KubexposeSpec{Source: &SourceReference{Kind: SourceKindDeployment, Name: "nginx"}, TargetNamespace: targetNamespace},
+		}
+		for _, name := range headersSecrets {
+			kexp.Spec.Notify = append(kexp.Spec.Notify, NotifyTarget{Name: name, URL: "https://example.com/hook", HeadersSecretName: name})
+		}
+		return kexp
+	}
+	getSecret := func(namespace, name string) authorizationv1.ResourceAttributes {
+		return authorizationv1.ResourceAttributes{Verb: "get", Resource: "secrets", Namespace: namespace, Name: name}
+	}
+	createIn := func(namespace string) []authorizationv1.ResourceAttributes {
+		var attributes []authorizationv1.ResourceAttributes
+		for _, a := range targetNamespaceAccess {
+			a.Namespace = namespace
+			attributes = append(attributes, a)
+		}
+		return attributes
+	}
+
+	tests := []struct {
+		name    string
+		old     *Kubexpose
+		kexp    *Kubexpose
+		access  []authorizationv1.ResourceAttributes
+		allowed bool
+	}{
+		{"same namespace", nil, newKubexpose("default"), nil, true},
+		{"headers secret allowed", nil, newKubexpose("default", "registry"), []authorizationv1.ResourceAttributes{getSecret("default", "registry")}, true},
+		{"headers secret without access", nil, newKubexpose("default", "registry"), nil, false},
+		{"headers secret of another user", nil, newKubexpose("default", "registry"), []authorizationv1.ResourceAttributes{getSecret("default", "team")}, false},
+		{"headers secret unchanged", newKubexpose("default", "registry"), newKubexpose("default", "registry"), nil, true},
+		{"headers secret changed", newKubexpose("default", "registry"), newKubexpose("default", "team"), nil, false},
+		{"target namespace allowed", nil, newKubexpose("apps"), createIn("apps"), true},
+		{"target namespace without access", nil, newKubexpose("apps"), nil, false},
+		{
+			"headers secret in the target namespace",
+			nil,
+			newKubexpose("apps", "registry"),
+			append(createIn("apps"), getSecret("default", "registry")),
+			false,
+		},
+		{"target namespace changed with the same headers secret", newKubexpose("default", "registry"), newKubexpose("apps", "registry"), createIn("apps"), false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &reviewingClient{Client: fake.NewClientBuilder().WithScheme(scheme).Build(), allowed: map[authorizationv1.ResourceAttributes]bool{}}
+			for _, attributes := range tc.access {
+				c.allowed[attributes] = true
+			}
+			v := &targetNamespaceValidator{client: c, decoder: decoder}
+
+			req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Create,
+				UserInfo:  authenticationv1.UserInfo{Username: "jane"},
+				Object:    rawExtension(t, tc.kexp),
+			}}
+			if tc.old != nil {
+				req.Operation = admissionv1.Update
+				req.OldObject = rawExtension(t, tc.old)
+			}
+
+			resp := v.Handle(context.Background(), req)
+			if resp.Allowed != tc.allowed {
+				t.Errorf("allowed %v (%v), want %v. reviewed %v", resp.Allowed, resp.Result, tc.allowed, c.reviewed)
+			}
+		})
+	}
+}
+
+func rawExtension(t *testing.T, obj runtime.Object) runtime.RawExtension {
+	t.Helper()
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return runtime.RawExtension{Raw: raw}
+}
@@ -0,0 +1,300 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	kubexposev1 "github.com/abhirockzz/kubexpose-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// access to the public url is restricted by proxies which run in the tunnel Pod. the tunnel points to the first proxy in the chain -
+// nginx (basic auth, IP filtering) -> oauth2-proxy (OAuth/OIDC) -> Service. each http port gets its own listener in each of the proxies
+const (
+	accessProxyImage      = "nginx:1.21-alpine"
+	accessProxyPort       = 8080
+	accessProxyConfigDir  = "/etc/kubexpose/nginx"
+	accessProxyConfigFile = "nginx.conf"
+	basicAuthDir          = "/etc/kubexpose/basic-auth"
+	basicAuthFile         = "auth"
+
+	oauthProxyImage = "quay.io/oauth2-proxy/oauth2-proxy:v7.1.3"
+	oauthProxyPort  = 4180
+
+	// hash of the Secrets used to restrict access. set on the tunnel Pod template so that the Pods are restarted when they change
+	accessHashAnnotation = "kubexpose.kubexpose.io/access-hash"
+)
+
+// accessProxyEnabled tells whether nginx is needed in front of the Service
+func accessProxyEnabled(kexp *kubexposev1.Kubexpose) bool {
+	access := kexp.Spec.Access
+	return access != nil && (access.BasicAuth != nil || len(access.AllowCIDRs) > 0 || len(access.DenyCIDRs) > 0)
+}
+
+// basicAuthEnabled tells whether nginx has to check the credentials
+func basicAuthEnabled(kexp *kubexposev1.Kubexpose) bool {
+	return kexp.Spec.Access != nil && kexp.Spec.Access.BasicAuth != nil
+}
+
+// oauthProxyEnabled tells whether oauth2-proxy is needed in front of the Service
+func oauthProxyEnabled(kexp *kubexposev1.Kubexpose) bool {
+	return kexp.Spec.Access != nil && kexp.Spec.Access.OAuth != nil
+}
+
+// tunnelAddress returns the address the tunnel for a port points to - the first proxy in the chain, or the Service port if access is not restricted
+func tunnelAddress(kexp *kubexposev1.Kubexpose, index int, port kubexposev1.PortSpec, serviceAddress string) string {
+	if port.Protocol != kubexposev1.ProtocolHTTP {
+		return serviceAddress
+	}
+	if accessProxyEnabled(kexp) {
+		return "localhost:" + strconv.Itoa(accessProxyPort+index)
+	}
+	if oauthProxyEnabled(kexp) {
+		return "localhost:" + strconv.Itoa(oauthProxyPort+index)
+	}
+	return serviceAddress
+}
+
+// validateAccess returns an error if access can't be restricted as specified
+func validateAccess(kexp *kubexposev1.Kubexpose, cfg tunnelConfig) error {
+	if !accessProxyEnabled(kexp) && !oauthProxyEnabled(kexp) {
+		return nil
+	}
+
+	for _, t := range cfg.tunnels {
+		if t.protocol != kubexposev1.ProtocolHTTP {
+			return permanentError{msg: fmt.Sprintf("access can't be restricted for protocol %s (port %s)", t.protocol, t.name)}
+		}
+	}
+
+	access := kexp.Spec.Access
+
+	if access.OAuth != nil && access.OAuth.Provider == "oidc" && access.OAuth.IssuerURL == "" {
+		return permanentError{msg: "issuerURL is required for oauth provider oidc"}
+	}
+
+	for _, cidr := range append(append([]string{}, access.AllowCIDRs...), access.DenyCIDRs...) {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return permanentError{msg: fmt.Sprintf("invalid CIDR %s", cidr)}
+		}
+	}
+
+	return nil
+}
+
+// accessProxyConfigData returns the nginx configuration with a server for each port. nil is returned if nginx is not needed
+func accessProxyConfigData(kexp *kubexposev1.Kubexpose, cfg tunnelConfig) map[string]string {
+	if !accessProxyEnabled(kexp) {
+		return nil
+	}
+
+	access := kexp.Spec.Access
+
+	var conf strings.Builder
+
+	conf.WriteString("worker_processes 1;\n")
+	conf.WriteString("pid /tmp/nginx.pid;\n")
+	conf.WriteString("events {\n  worker_connections 1024;\n}\n")
+	conf.WriteString("http {\n")
+	// the client address is forwarded by the tunnel, which connects from within the Pod
+	conf.WriteString("  set_real_ip_from 127.0.0.1;\n")
+	conf.WriteString("  real_ip_header X-Forwarded-For;\n")
+	conf.WriteString("  real_ip_recursive on;\n")
+
+	for i, t := range cfg.tunnels {
+		upstream := t.serviceAddress
+		if oauthProxyEnabled(kexp) {
+			upstream = "localhost:" + strconv.Itoa(oauthProxyPort+i)
+		}
+
+		fmt.Fprintf(&conf, "  # port %s\n", t.name)
+		conf.WriteString("  server {\n")
+		fmt.Fprintf(&conf, "    listen %d;\n", accessProxyPort+i)
+		// the first matching rule wins, hence deny comes first
+		for _, cidr := range access.DenyCIDRs {
+			fmt.Fprintf(&conf, "    deny %s;\n", cidr)
+		}
+		for _, cidr := range access.AllowCIDRs {
+			fmt.Fprintf(&conf, "    allow %s;\n", cidr)
+		}
+		if len(access.AllowCIDRs) > 0 {
+			conf.WriteString("    deny all;\n")
+		}
+		if basicAuthEnabled(kexp) {
+			conf.WriteString("    auth_basic \"kubexpose\";\n")
+			fmt.Fprintf(&conf, "    auth_basic_user_file %s/%s;\n", basicAuthDir, basicAuthFile)
+		}
+		conf.WriteString("    location / {\n")
+		fmt.Fprintf(&conf, "      proxy_pass http://%s;\n", upstream)
+		conf.WriteString("      proxy_http_version 1.1;\n")
+		conf.WriteString("      proxy_set_header Host $host;\n")
+		conf.WriteString("      proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;\n")
+		conf.WriteString("      proxy_set_header X-Forwarded-Proto https;\n")
+		conf.WriteString("    }\n")
+		conf.WriteString("  }\n")
+	}
+
+	conf.WriteString("}\n")
+
+	return map[string]string{accessProxyConfigFile: conf.String()}
+}
+
+// accessProxyPodSpec adds the proxies (and their volumes) which restrict access to the public url to the tunnel Pod spec
+func accessProxyPodSpec(kexp *kubexposev1.Kubexpose, cfg tunnelConfig, spec *corev1.PodSpec) {
+	if accessProxyEnabled(kexp) {
+		container := corev1.Container{
+			Name:    "access-proxy",
+			Image:   accessProxyImage,
+			Command: []string{"nginx"},
+			Args:    []string{"-c", accessProxyConfigDir + "/" + accessProxyConfigFile, "-g", "daemon off;"},
+			VolumeMounts: []corev1.VolumeMount{
+				{Name: "access-proxy-config", MountPath: accessProxyConfigDir, ReadOnly: true},
+			},
+		}
+
+		spec.Volumes = append(spec.Volumes, corev1.Volume{
+			Name: "access-proxy-config",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: cfg.configMapName},
+					Items:                []corev1.KeyToPath{{Key: accessProxyConfigFile, Path: accessProxyConfigFile}},
+				},
+			},
+		})
+
+		if basicAuthEnabled(kexp) {
+			basicAuth := kexp.Spec.Access.BasicAuth
+
+			container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{Name: "basic-auth", MountPath: basicAuthDir, ReadOnly: true})
+			spec.Volumes = append(spec.Volumes, corev1.Volume{
+				Name: "basic-auth",
+				VolumeSource: corev1.VolumeSource{
+					Secret: &corev1.SecretVolumeSource{
+						SecretName: basicAuth.SecretRef.Name,
+						Items:      []corev1.KeyToPath{{Key: basicAuthKey(basicAuth), Path: basicAuthFile}},
+					},
+				},
+			})
+		}
+
+		spec.Containers = append(spec.Containers, container)
+	}
+
+	if oauthProxyEnabled(kexp) {
+		oauth := kexp.Spec.Access.OAuth
+
+		for i, t := range cfg.tunnels {
+			args := []string{
+				"--http-address=0.0.0.0:" + strconv.Itoa(oauthProxyPort+i),
+				"--upstream=http://" + t.serviceAddress,
+				"--provider=" + oauth.Provider,
+				"--reverse-proxy=true",
+				"--cookie-secure=true",
+				"--skip-provider-button=true",
+			}
+			if oauth.IssuerURL != "" {
+				args = append(args, "--oidc-issuer-url="+oauth.IssuerURL)
+			}
+			if len(oauth.AllowedEmailDomains) == 0 {
+				args = append(args, "--email-domain=*")
+			}
+			for _, domain := range oauth.AllowedEmailDomains {
+				args = append(args, "--email-domain="+domain)
+			}
+
+			spec.Containers = append(spec.Containers, corev1.Container{
+				Name:  "oauth2-proxy-" + t.name,
+				Image: oauthProxyImage,
+				Args:  args,
+				Env: []corev1.EnvVar{
+					secretEnv("OAUTH2_PROXY_CLIENT_ID", oauth.SecretName, kubexposev1.OAuthClientIDKey),
+					secretEnv("OAUTH2_PROXY_CLIENT_SECRET", oauth.SecretName, kubexposev1.OAuthClientSecretKey),
+					secretEnv("OAUTH2_PROXY_COOKIE_SECRET", oauth.SecretName, kubexposev1.OAuthCookieSecretKey),
+				},
+			})
+		}
+	}
+}
+
+func secretEnv(name, secret, key string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: secret}, Key: key},
+		},
+	}
+}
+
+func basicAuthKey(basicAuth *kubexposev1.BasicAuthSpec) string {
+	if basicAuth.SecretRef.Key == "" {
+		return kubexposev1.DefaultBasicAuthSecretKey
+	}
+	return basicAuth.SecretRef.Key
+}
+
+// accessSecretNames returns the names of the Secrets used to restrict access
+func accessSecretNames(kexp *kubexposev1.Kubexpose) []string {
+	var names []string
+	if basicAuthEnabled(kexp) {
+		names = append(names, kexp.Spec.Access.BasicAuth.SecretRef.Name)
+	}
+	if oauthProxyEnabled(kexp) {
+		names = append(names, kexp.Spec.Access.OAuth.SecretName)
+	}
+	return names
+}
+
+// getAccessHash makes sure that the Secrets used to restrict access exist and returns a hash of the keys used from them.
+// an empty string is returned if access is not restricted
+func (r *KubexposeReconciler) getAccessHash(ctx context.Context, kexp *kubexposev1.Kubexpose) (string, error) {
+	type secretKeys struct {
+		name string
+		keys []string
+	}
+
+	var secrets []secretKeys
+	if basicAuthEnabled(kexp) {
+		basicAuth := kexp.Spec.Access.BasicAuth
+		secrets = append(secrets, secretKeys{name: basicAuth.SecretRef.Name, keys: []string{basicAuthKey(basicAuth)}})
+	}
+	if oauthProxyEnabled(kexp) {
+		keys := []string{kubexposev1.OAuthClientIDKey, kubexposev1.OAuthClientSecretKey, kubexposev1.OAuthCookieSecretKey}
+		secrets = append(secrets, secretKeys{name: kexp.Spec.Access.OAuth.SecretName, keys: keys})
+	}
+
+	if len(secrets) == 0 {
+		return "", nil
+	}
+
+	h := sha256.New()
+	for _, s := range secrets {
+		var secret corev1.Secret
+		err := r.Get(ctx, types.NamespacedName{Namespace: kexp.ExposedNamespace(), Name: s.name}, &secret)
+		if err != nil {
+			if client.IgnoreNotFound(err) == nil {
+				return "", permanentError{msg: fmt.Sprintf("secret %s/%s does not exist", kexp.ExposedNamespace(), s.name)}
+			}
+			return "", err
+		}
+
+		for _, key := range s.keys {
+			value, ok := secret.Data[key]
+			if !ok || len(value) == 0 {
+				return "", permanentError{msg: fmt.Sprintf("secret %s/%s does not have key %s", kexp.ExposedNamespace(), s.name, key)}
+			}
+			h.Write([]byte(key))
+			h.Write([]byte{0})
+			h.Write(value)
+			h.Write([]byte{0})
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	kubexposev1 "github.com/abhirockzz/kubexpose-operator/api/v1"
+)
+
+func TestAccessProxyConfigData(t *testing.T) {
+	cfg := tunnelConfig{tunnels: []tunnel{
+		{name: "web", serviceAddress: "nginx-svc-app:80"},
+		{name: "api", serviceAddress: "nginx-svc-app:8080"},
+	}}
+
+	kexp := &kubexposev1.Kubexpose{Spec: kubexposev1.KubexposeSpec{Access: &kubexposev1.AccessSpec{
+		AllowCIDRs: []string{"203.0.113.0/24", "198.51.100.7/32"},
+		DenyCIDRs:  []string{"203.0.113.66/32"},
+	}}}
+
+	conf := accessProxyConfigData(kexp, cfg)[accessProxyConfigFile]
+
+	// the client address is taken from X-Forwarded-For only if the request comes from the tunnel in the same Pod
+	if !strings.Contains(conf, "set_real_ip_from 127.0.0.1;\n  real_ip_header X-Forwarded-For;") {
+		t.Errorf("client address is not trusted from the tunnel only:\n%s", conf)
+	}
+	if strings.Count(conf, "set_real_ip_from") != 1 {
+		t.Errorf("client address is trusted from other addresses:\n%s", conf)
+	}
+
+	servers := strings.Split(conf, "  server {")[1:]
+	if len(servers) != 2 {
+		t.Fatalf("expected a server per port, got %d:\n%s", len(servers), conf)
+	}
+
+	for i, server := range servers {
+		// nginx applies the first matching rule
+		rules := []string{"deny 203.0.113.66/32;", "allow 203.0.113.0/24;", "allow 198.51.100.7/32;", "deny all;"}
+		last := -1
+		for _, rule := range rules {
+			index := strings.Index(server, rule)
+			if index <= last {
+				t.Errorf("rule %q is missing or out of order in server %d:\n%s", rule, i, server)
+			}
+			last = index
+		}
+
+		if upstream := "proxy_pass http://" + cfg.tunnels[i].serviceAddress + ";"; !strings.Contains(server, upstream) {
+			t.Errorf("server %d does not point to the service (%s):\n%s", i, upstream, server)
+		}
+		if strings.Contains(server, "auth_basic") {
+			t.Errorf("unexpected basic auth in server %d:\n%s", i, server)
+		}
+	}
+
+	// blocked clients only - the others are allowed
+	kexp.Spec.Access = &kubexposev1.AccessSpec{DenyCIDRs: []string{"203.0.113.66/32"}}
+	conf = accessProxyConfigData(kexp, cfg)[accessProxyConfigFile]
+	if strings.Contains(conf, "deny all;") {
+		t.Errorf("all clients are denied without allowCIDRs:\n%s", conf)
+	}
+
+	// oauth2-proxy comes after nginx in the chain, and points to the Service
+	kexp.Spec.Access = &kubexposev1.AccessSpec{
+		AllowCIDRs: []string{"203.0.113.0/24"},
+		BasicAuth:  &kubexposev1.BasicAuthSpec{SecretRef: kubexposev1.SecretKeyReference{Name: "users"}},
+		OAuth:      &kubexposev1.OAuthSpec{Provider: "github", SecretName: "oauth"},
+	}
+	conf = accessProxyConfigData(kexp, cfg)[accessProxyConfigFile]
+	servers = strings.Split(conf, "  server {")[1:]
+	for i, upstream := range []string{"proxy_pass http://localhost:4180;", "proxy_pass http://localhost:4181;"} {
+		if !strings.Contains(servers[i], upstream) {
+			t.Errorf("server %d does not point to oauth2-proxy (%s):\n%s", i, upstream, servers[i])
+		}
+		if !strings.Contains(servers[i], "auth_basic_user_file "+basicAuthDir+"/"+basicAuthFile+";") {
+			t.Errorf("server %d does not use basic auth:\n%s", i, servers[i])
+		}
+	}
+
+	// nginx is not needed for oauth only
+	kexp.Spec.Access = &kubexposev1.AccessSpec{OAuth: &kubexposev1.OAuthSpec{Provider: "github", SecretName: "oauth"}}
+	if data := accessProxyConfigData(kexp, cfg); data != nil {
+		t.Errorf("unexpected nginx configuration for oauth only: %v", data)
+	}
+}
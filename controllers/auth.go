@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	kubexposev1 "github.com/abhirockzz/kubexpose-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// index of Kubexpose resources by the <namespace>/<name> of the Secrets they use
+	secretIndexField = ".spec.secrets"
+
+	// hash of the authtoken. set on the tunnel Pod template so that the Pods are restarted when the Secret changes
+	authHashAnnotation = "kubexpose.kubexpose.io/auth-hash"
+)
+
+// authSecretFor returns the Secret with the authtoken for the Kubexpose resource - either spec.authSecretRef or the operator default.
+// nil is returned if neither of them is set
+func (r *KubexposeReconciler) authSecretFor(kexp *kubexposev1.Kubexpose) *kubexposev1.SecretKeyReference {
+	ref := kexp.Spec.AuthSecretRef
+	if ref == nil {
+		if r.DefaultAuthSecret == "" {
+			return nil
+		}
+		ref = &kubexposev1.SecretKeyReference{Name: r.DefaultAuthSecret}
+	}
+
+	if ref.Key == "" {
+		return &kubexposev1.SecretKeyReference{Name: ref.Name, Key: kubexposev1.DefaultAuthSecretKey}
+	}
+	return ref
+}
+
+// getAuthToken looks up the Secret with the authtoken. the authtoken is not read by kubexpose - a reference to it is returned,
+// along with a hash of its value. nil is returned if the tunnel is to be started anonymously
+func (r *KubexposeReconciler) getAuthToken(ctx context.Context, kexp *kubexposev1.Kubexpose) (*corev1.SecretKeySelector, string, error) {
+	ref := r.authSecretFor(kexp)
+	if ref == nil {
+		return nil, "", nil
+	}
+
+	var secret corev1.Secret
+	err := r.Get(ctx, types.NamespacedName{Namespace: kexp.ExposedNamespace(), Name: ref.Name}, &secret)
+	if err != nil {
+		if client.IgnoreNotFound(err) == nil {
+			return nil, "", permanentError{msg: fmt.Sprintf("secret %s/%s does not exist", kexp.ExposedNamespace(), ref.Name)}
+		}
+		return nil, "", err
+	}
+
+	token, ok := secret.Data[ref.Key]
+	if !ok || len(token) == 0 {
+		return nil, "", permanentError{msg: fmt.Sprintf("secret %s/%s does not have key %s", kexp.ExposedNamespace(), ref.Name, ref.Key)}
+	}
+
+	hash := sha256.Sum256(token)
+
+	selector := &corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: ref.Name},
+		Key:                  ref.Key,
+	}
+	return selector, hex.EncodeToString(hash[:]), nil
+}
+
+// indexSecrets is used to index Kubexpose resources by the Secrets they use - for the authtoken and to restrict access
+func (r *KubexposeReconciler) indexSecrets(obj client.Object) []string {
+	kexp := obj.(*kubexposev1.Kubexpose)
+
+	names := accessSecretNames(kexp)
+	if ref := r.authSecretFor(kexp); ref != nil {
+		names = append(names, ref.Name)
+	}
+
+	var keys []string
+	for _, name := range names {
+		keys = append(keys, kexp.ExposedNamespace()+"/"+name)
+	}
+	return keys
+}
+
+// mapSecretToKubexpose maps a Secret to the Kubexpose resources which use it
+func (r *KubexposeReconciler) mapSecretToKubexpose(obj client.Object) []reconcile.Request {
+	var kexps kubexposev1.KubexposeList
+	err := r.List(context.Background(), &kexps, client.MatchingFields{secretIndexField: obj.GetNamespace() + "/" + obj.GetName()})
+	if err != nil {
+		log.Log.Error(err, "failed to list kubexpose resources for secret", "namespace", obj.GetNamespace(), "name", obj.GetName())
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, kexp := range kexps.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: kexp.Namespace, Name: kexp.Name}})
+	}
+	return requests
+}
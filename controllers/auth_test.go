@@ -0,0 +1,259 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"sort"
+	"testing"
+
+	kubexposev1 "github.com/abhirockzz/kubexpose-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// indexedClient filters the Kubexpose resources listed using client.MatchingFields with the index functions, the way the cache does.
+// the fake client ignores field selectors
+type indexedClient struct {
+	client.Client
+	indexes map[string]client.IndexerFunc
+}
+
+func (c indexedClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	listOpts := client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+
+	kexps, ok := list.(*kubexposev1.KubexposeList)
+	if !ok || listOpts.FieldSelector == nil {
+		return c.Client.List(ctx, list, opts...)
+	}
+
+	err := c.Client.List(ctx, kexps, &client.ListOptions{Namespace: listOpts.Namespace, LabelSelector: listOpts.LabelSelector})
+	if err != nil {
+		return err
+	}
+
+	var matching []kubexposev1.Kubexpose
+	for i := range kexps.Items {
+		matches := true
+		for _, requirement := range listOpts.FieldSelector.Requirements() {
+			found := false
+			for _, value := range c.indexes[requirement.Field](&kexps.Items[i]) {
+				found = found || value == requirement.Value
+			}
+			matches = matches && found
+		}
+		if matches {
+			matching = append(matching, kexps.Items[i])
+		}
+	}
+	kexps.Items = matching
+	return nil
+}
+
+func requestNames(requests []reconcile.Request) []string {
+	var names []string
+	for _, req := range requests {
+		names = append(names, req.String())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sha256Hex(value string) string {
+	hash := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(hash[:])
+}
+
+func TestGetAuthToken(t *testing.T) {
+	secrets := []client.Object{
+		&corev1.Secret{ObjectMeta: metaV1.ObjectMeta{Namespace: "apps", Name: "ngrok"}, Data: map[string][]byte{"authtoken": []byte("token-1"), "other": []byte("token-2")}},
+		&corev1.Secret{ObjectMeta: metaV1.ObjectMeta{Namespace: "apps", Name: "empty"}, Data: map[string][]byte{"authtoken": nil}},
+	}
+
+	tests := []struct {
+		name          string
+		ref           *kubexposev1.SecretKeyReference
+		defaultSecret string
+		want          *corev1.SecretKeySelector
+		wantToken     string
+		permanent     bool
+	}{
+		{name: "anonymous"},
+		{
+			name:      "default key",
+			ref:       &kubexposev1.SecretKeyReference{Name: "ngrok"},
+			want:      &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "ngrok"}, Key: "authtoken"},
+			wantToken: "token-1",
+		},
+		{
+			name:      "key",
+			ref:       &kubexposev1.SecretKeyReference{Name: "ngrok", Key: "other"},
+			want:      &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "ngrok"}, Key: "other"},
+			wantToken: "token-2",
+		},
+		{
+			name:          "operator default",
+			defaultSecret: "ngrok",
+			want:          &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "ngrok"}, Key: "authtoken"},
+			wantToken:     "token-1",
+		},
+		{name: "secret does not exist", ref: &kubexposev1.SecretKeyReference{Name: "missing"}, permanent: true},
+		{name: "key does not exist", ref: &kubexposev1.SecretKeyReference{Name: "ngrok", Key: "missing"}, permanent: true},
+		{name: "empty key", ref: &kubexposev1.SecretKeyReference{Name: "empty"}, permanent: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			kexp := &kubexposev1.Kubexpose{
+				ObjectMeta: metaV1.ObjectMeta{Namespace: "default", Name: "app"},
+				Spec:       kubexposev1.KubexposeSpec{TargetNamespace: "apps", AuthSecretRef: tc.ref},
+			}
+			r := &KubexposeReconciler{Client: fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(secrets...).Build(), DefaultAuthSecret: tc.defaultSecret}
+
+			selector, hash, err := r.getAuthToken(context.Background(), kexp)
+			if tc.permanent {
+				if !isPermanent(err) {
+					t.Fatalf("expected a permanent error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(selector, tc.want) {
+				t.Errorf("selector %v, want %v", selector, tc.want)
+			}
+
+			wantHash := ""
+			if tc.wantToken != "" {
+				wantHash = sha256Hex(tc.wantToken)
+			}
+			if hash != wantHash {
+				t.Errorf("hash %s, want %s", hash, wantHash)
+			}
+		})
+	}
+}
+
+func TestAuthTokenRotation(t *testing.T) {
+	secret := &corev1.Secret{ObjectMeta: metaV1.ObjectMeta{Namespace: "default", Name: "ngrok"}, Data: map[string][]byte{"authtoken": []byte("token-1")}}
+	kexp := &kubexposev1.Kubexpose{
+		ObjectMeta: metaV1.ObjectMeta{Namespace: "default", Name: "app"},
+		Spec:       kubexposev1.KubexposeSpec{AuthSecretRef: &kubexposev1.SecretKeyReference{Name: "ngrok"}},
+	}
+
+	ctx := context.Background()
+	r := &KubexposeReconciler{Client: fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(secret).Build()}
+
+	hash := func() string {
+		t.Helper()
+		_, hash, err := r.getAuthToken(ctx, kexp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+
+	original := hash()
+	if hash() != original {
+		t.Error("hash changed although the authtoken did not")
+	}
+
+	// other keys don't matter
+	secret.Data["unrelated"] = []byte("value")
+	if err := r.Update(ctx, secret); err != nil {
+		t.Fatal(err)
+	}
+	if hash() != original {
+		t.Error("hash changed although the authtoken did not")
+	}
+
+	secret.Data["authtoken"] = []byte("token-2")
+	if err := r.Update(ctx, secret); err != nil {
+		t.Fatal(err)
+	}
+	if hash() == original {
+		t.Error("hash did not change after the authtoken was rotated")
+	}
+}
+
+func TestMapSecretToKubexpose(t *testing.T) {
+	kexps := []client.Object{
+		&kubexposev1.Kubexpose{
+			ObjectMeta: metaV1.ObjectMeta{Namespace: "default", Name: "authtoken"},
+			Spec:       kubexposev1.KubexposeSpec{AuthSecretRef: &kubexposev1.SecretKeyReference{Name: "ngrok"}},
+		},
+		&kubexposev1.Kubexpose{
+			ObjectMeta: metaV1.ObjectMeta{Namespace: "default", Name: "cross-namespace"},
+			Spec:       kubexposev1.KubexposeSpec{TargetNamespace: "apps", AuthSecretRef: &kubexposev1.SecretKeyReference{Name: "ngrok"}},
+		},
+		&kubexposev1.Kubexpose{
+			ObjectMeta: metaV1.ObjectMeta{Namespace: "default", Name: "basic-auth"},
+			Spec: kubexposev1.KubexposeSpec{
+				AuthSecretRef: &kubexposev1.SecretKeyReference{Name: "ngrok-team"},
+				Access:        &kubexposev1.AccessSpec{BasicAuth: &kubexposev1.BasicAuthSpec{SecretRef: kubexposev1.SecretKeyReference{Name: "htpasswd"}}},
+			},
+		},
+		&kubexposev1.Kubexpose{ObjectMeta: metaV1.ObjectMeta{Namespace: "default", Name: "operator-default"}},
+	}
+
+	tests := []struct {
+		name          string
+		defaultSecret string
+		secret        types.NamespacedName
+		labels        map[string]string
+		want          []string
+	}{
+		{"authtoken", "", types.NamespacedName{Namespace: "default", Name: "ngrok"}, nil, []string{"default/authtoken"}},
+		{"target namespace", "", types.NamespacedName{Namespace: "apps", Name: "ngrok"}, nil, []string{"default/cross-namespace"}},
+		{"basic auth", "", types.NamespacedName{Namespace: "default", Name: "htpasswd"}, nil, []string{"default/basic-auth"}},
+		{"operator default", "ngrok", types.NamespacedName{Namespace: "default", Name: "ngrok"}, nil, []string{"default/authtoken", "default/operator-default"}},
+		{"published url", "", types.NamespacedName{Namespace: "default", Name: "app-url"}, ownerLabels(kexps[0].(*kubexposev1.Kubexpose)), []string{"default/authtoken"}},
+		{"unused", "", types.NamespacedName{Namespace: "default", Name: "unrelated"}, nil, nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &KubexposeReconciler{DefaultAuthSecret: tc.defaultSecret}
+			r.Client = indexedClient{
+				Client:  fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(kexps...).Build(),
+				indexes: map[string]client.IndexerFunc{secretIndexField: r.indexSecrets},
+			}
+
+			// only the metadata of the Secrets is watched
+			secret := &metaV1.PartialObjectMetadata{ObjectMeta: metaV1.ObjectMeta{Namespace: tc.secret.Namespace, Name: tc.secret.Name, Labels: tc.labels}}
+			if got := requestNames(mapAll(r.mapSecretToKubexpose, mapToKubexpose)(secret)); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("requests %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestIndexSecrets(t *testing.T) {
+	kexp := &kubexposev1.Kubexpose{
+		ObjectMeta: metaV1.ObjectMeta{Namespace: "default", Name: "app"},
+		Spec: kubexposev1.KubexposeSpec{
+			TargetNamespace: "apps",
+			Access: &kubexposev1.AccessSpec{
+				BasicAuth: &kubexposev1.BasicAuthSpec{SecretRef: kubexposev1.SecretKeyReference{Name: "htpasswd"}},
+				OAuth:     &kubexposev1.OAuthSpec{Provider: "github", SecretName: "oauth"},
+			},
+		},
+	}
+
+	r := &KubexposeReconciler{}
+	if got, want := r.indexSecrets(kexp), []string{"apps/htpasswd", "apps/oauth"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("indexSecrets() = %v, want %v", got, want)
+	}
+
+	r.DefaultAuthSecret = "ngrok"
+	if got, want := r.indexSecrets(kexp), []string{"apps/htpasswd", "apps/oauth", "apps/ngrok"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("indexSecrets() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,315 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	kubexposev1 "github.com/abhirockzz/kubexpose-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	cloudflaredProviderName = "cloudflared"
+	cloudflaredImage        = "cloudflare/cloudflared"
+	cloudflaredMetricsPort  = 2000
+
+	// the ingress rules of named tunnels are mounted from the tunnel ConfigMap
+	cloudflaredConfigDir  = "/etc/cloudflared"
+	cloudflaredConfigFile = "cloudflared.yml"
+
+	// logged by named tunnels when the ingress rules configured in the Cloudflare dashboard are applied
+	cloudflaredRemoteConfigLog = "Updated to new configuration"
+)
+
+// cloudflared also prints the quick tunnel url in its logs once the tunnel is created
+var cloudflaredURLPattern = regexp.MustCompile(`https://[a-z0-9-]+\.trycloudflare\.com`)
+
+// hostnames in the (escaped) JSON of the remote configuration log e.g. config="{\"ingress\":[{\"hostname\":\"app.example.com\", ...
+var cloudflaredHostnamePattern = regexp.MustCompile(`\\?"hostname\\?":\s*\\?"([^"\\]+)`)
+
+func init() {
+	registerProvider(cloudflaredProvider{})
+}
+
+// cloudflaredProvider exposes the Service using a Cloudflare quick tunnel (https://developers.cloudflare.com/cloudflare-one/connections/connect-apps),
+// or a named tunnel if a hostname is reserved
+type cloudflaredProvider struct{}
+
+func (cloudflaredProvider) Name() string {
+	return cloudflaredProviderName
+}
+
+// Validate rejects anything other than http since quick tunnels can only be accessed over https.
+// a reserved hostname needs a named tunnel, which is started using the tunnel token in the auth Secret and serves a single port
+func (cloudflaredProvider) Validate(kexp *kubexposev1.Kubexpose, cfg tunnelConfig) error {
+	if cfg.region != "" {
+		return permanentError{msg: "cloudflared does not support region"}
+	}
+	for _, t := range cfg.tunnels {
+		if t.protocol != kubexposev1.ProtocolHTTP {
+			return permanentError{msg: fmt.Sprintf("cloudflared does not support protocol %s (port %s)", t.protocol, t.name)}
+		}
+		if t.subdomain != "" {
+			return permanentError{msg: fmt.Sprintf("cloudflared does not support subdomain (port %s), use hostname instead", t.name)}
+		}
+		if t.hostname == "" {
+			continue
+		}
+		if cfg.authToken == nil {
+			return permanentError{msg: fmt.Sprintf("cloudflared requires a tunnel token in authSecretRef for hostname %s", t.hostname)}
+		}
+		if len(cfg.tunnels) > 1 {
+			return permanentError{msg: "cloudflared supports hostname with a single port only"}
+		}
+	}
+	return nil
+}
+
+// ConfigData returns the ingress rules which route the reserved hostname to the Service port. quick tunnels are configured
+// using command line arguments, hence nil is returned if there is no hostname
+func (cloudflaredProvider) ConfigData(kexp *kubexposev1.Kubexpose, cfg tunnelConfig) (map[string]string, error) {
+	var config cloudflaredConfig
+	for _, t := range cfg.tunnels {
+		if t.hostname != "" {
+			config.Ingress = append(config.Ingress, cloudflaredIngressRule{Hostname: t.hostname, Service: "http://" + t.address})
+		}
+	}
+	if len(config.Ingress) == 0 {
+		return nil, nil
+	}
+	// cloudflared requires a catch-all rule
+	config.Ingress = append(config.Ingress, cloudflaredIngressRule{Service: "http_status:404"})
+
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{cloudflaredConfigFile: string(data)}, nil
+}
+
+// PodSpec runs one cloudflared container per port, each pointing to the Service port. It's equivalent to - cloudflared tunnel --url http://<service>:<port>.
+// a quick tunnel exposes a single url, hence the separate containers. each container serves its metrics on a different port.
+// with a hostname, it's a named tunnel instead - cloudflared tunnel --config cloudflared.yml run, with the ingress rules from ConfigData
+func (cloudflaredProvider) PodSpec(kexp *kubexposev1.Kubexpose, cfg tunnelConfig) corev1.PodSpec {
+	var containers []corev1.Container
+	var volumes []corev1.Volume
+
+	for i, t := range cfg.tunnels {
+		metricsPort := cloudflaredMetricsPort + i
+
+		args := []string{
+			"tunnel",
+			"--no-autoupdate",
+			"--metrics", fmt.Sprintf("0.0.0.0:%d", metricsPort),
+		}
+		var env []corev1.EnvVar
+		var volumeMounts []corev1.VolumeMount
+
+		// named tunnel - cloudflared picks up the token from TUNNEL_TOKEN. the ingress rules in the Cloudflare dashboard
+		// (if any) take precedence over the ones in the configuration file
+		if t.hostname != "" {
+			args = append(args, "--config", cloudflaredConfigDir+"/"+cloudflaredConfigFile, "run")
+			env = append(env, corev1.EnvVar{
+				Name:      "TUNNEL_TOKEN",
+				ValueFrom: &corev1.EnvVarSource{SecretKeyRef: cfg.authToken},
+			})
+			volumeMounts = append(volumeMounts, corev1.VolumeMount{Name: "config", MountPath: cloudflaredConfigDir, ReadOnly: true})
+			volumes = append(volumes, corev1.Volume{
+				Name: "config",
+				VolumeSource: corev1.VolumeSource{
+					ConfigMap: &corev1.ConfigMapVolumeSource{
+						LocalObjectReference: corev1.LocalObjectReference{Name: cfg.configMapName},
+					},
+				},
+			})
+		} else {
+			args = append(args, "--url", "http://"+t.address)
+		}
+
+		containers = append(containers, corev1.Container{
+			Name:         cloudflaredContainerName(t),
+			Image:        cloudflaredImage,
+			Args:         args,
+			Env:          env,
+			VolumeMounts: volumeMounts,
+			Ports:        []corev1.ContainerPort{{ContainerPort: int32(metricsPort)}},
+			ReadinessProbe: &corev1.Probe{
+				Handler: corev1.Handler{
+					HTTPGet: &corev1.HTTPGetAction{
+						Path: "/ready",
+						Port: intstr.FromInt(metricsPort),
+					},
+				},
+			},
+		})
+	}
+
+	return corev1.PodSpec{Containers: containers, Volumes: volumes}
+}
+
+// DiscoverURLs asks the cloudflared metrics server of each container for the quick tunnel hostname.
+// named tunnels are reachable at the hostnames in their ingress rules - HealthCheck has already made sure that they are connected
+func (p cloudflaredProvider) DiscoverURLs(ctx context.Context, agent tunnelAgent, cfg tunnelConfig) (map[string]string, error) {
+	urls := map[string]string{}
+
+	for i, t := range cfg.tunnels {
+		if t.hostname != "" {
+			hostname, err := p.namedTunnelHostname(ctx, agent, t)
+			if err != nil {
+				return nil, fmt.Errorf("port %s: %w", t.name, err)
+			}
+			urls[t.name] = "https://" + hostname
+			continue
+		}
+
+		url, err := p.discoverURL(ctx, agent, t, cloudflaredMetricsPort+i)
+		if err != nil {
+			return nil, fmt.Errorf("port %s: %w", t.name, err)
+		}
+		urls[t.name] = url
+	}
+
+	return urls, nil
+}
+
+// older cloudflared versions do not have the /quicktunnel endpoint - the url is looked up in the cloudflared logs instead
+func (cloudflaredProvider) discoverURL(ctx context.Context, agent tunnelAgent, t tunnel, metricsPort int) (string, error) {
+	resp, err := agent.Get(ctx, metricsPort, "/quicktunnel")
+	if err == nil {
+		var quickTunnel cloudflaredQuickTunnel
+		if err := json.Unmarshal(resp, &quickTunnel); err != nil {
+			return "", urlMalformedError{msg: "invalid response from cloudflared: " + err.Error()}
+		}
+		if quickTunnel.Hostname == "" {
+			return "", urlNotReadyError{msg: "cloudflared tunnel is not ready"}
+		}
+		return "https://" + quickTunnel.Hostname, nil
+	}
+
+	if !errors.IsNotFound(err) {
+		return "", err
+	}
+
+	logs, err := agent.Logs(ctx, cloudflaredContainerName(t))
+	if err != nil {
+		return "", err
+	}
+
+	urls := cloudflaredURLPattern.FindAll(logs, -1)
+	if len(urls) == 0 {
+		return "", urlNotReadyError{msg: "cloudflared tunnel url not found"}
+	}
+
+	// the latest one wins
+	return string(urls[len(urls)-1]), nil
+}
+
+// namedTunnelHostname returns the hostname at which the named tunnel is reachable. the ingress rules configured in the Cloudflare
+// dashboard replace the ones in the configuration file - if the reserved hostname is not one of them, the first one is returned
+// so that the ReservedName condition reports it
+func (cloudflaredProvider) namedTunnelHostname(ctx context.Context, agent tunnelAgent, t tunnel) (string, error) {
+	logs, err := agent.Logs(ctx, cloudflaredContainerName(t))
+	if err != nil {
+		return "", err
+	}
+
+	hostnames, remote := cloudflaredRemoteHostnames(logs)
+	if !remote {
+		return t.hostname, nil
+	}
+	if len(hostnames) == 0 {
+		return "", urlNotReadyError{msg: "no public hostname is configured for the cloudflared tunnel"}
+	}
+	for _, hostname := range hostnames {
+		if strings.EqualFold(hostname, t.hostname) {
+			return hostname, nil
+		}
+	}
+	return hostnames[0], nil
+}
+
+// cloudflaredRemoteHostnames returns the hostnames in the latest remote configuration logged by cloudflared.
+// false is returned if the tunnel is not configured remotely
+func cloudflaredRemoteHostnames(logs []byte) ([]string, bool) {
+	var config string
+	for _, line := range strings.Split(string(logs), "\n") {
+		if strings.Contains(line, cloudflaredRemoteConfigLog) {
+			config = line
+		}
+	}
+	if config == "" {
+		return nil, false
+	}
+
+	var hostnames []string
+	for _, match := range cloudflaredHostnamePattern.FindAllStringSubmatch(config, -1) {
+		hostnames = append(hostnames, match[1])
+	}
+	return hostnames, true
+}
+
+// HealthCheck confirms that each cloudflared container has at least one connection to the Cloudflare edge.
+// /ready returns a non 200 response otherwise
+func (cloudflaredProvider) HealthCheck(ctx context.Context, agent tunnelAgent, cfg tunnelConfig) error {
+	for i, t := range cfg.tunnels {
+		_, err := agent.Get(ctx, cloudflaredMetricsPort+i, "/ready")
+		if err != nil {
+			return fmt.Errorf("port %s: %v", t.name, err)
+		}
+	}
+	return nil
+}
+
+// SessionActive checks whether any of the cloudflared containers has connections to the Cloudflare edge
+func (cloudflaredProvider) SessionActive(ctx context.Context, agent tunnelAgent, cfg tunnelConfig) (bool, error) {
+	for i := range cfg.tunnels {
+		resp, err := agent.Get(ctx, cloudflaredMetricsPort+i, "/ready")
+		if err != nil {
+			// /ready returns 503 if there are no connections
+			if errors.IsServiceUnavailable(err) {
+				continue
+			}
+			return false, err
+		}
+
+		var ready cloudflaredReady
+		err = json.Unmarshal(resp, &ready)
+		if err != nil {
+			return false, err
+		}
+		if ready.ReadyConnections > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func cloudflaredContainerName(t tunnel) string {
+	return "cloudflared-" + t.name
+}
+
+// json response for cloudflared readiness - curl http://localhost:2000/ready
+type cloudflaredReady struct {
+	ReadyConnections int `json:"readyConnections"`
+}
+
+// cloudflared configuration file - https://developers.cloudflare.com/cloudflare-one/connections/connect-apps/install-and-setup/tunnel-guide/local/local-management/ingress
+type cloudflaredConfig struct {
+	Ingress []cloudflaredIngressRule `json:"ingress"`
+}
+
+type cloudflaredIngressRule struct {
+	Hostname string `json:"hostname,omitempty"`
+	Service  string `json:"service"`
+}
+
+// json response for cloudflared quick tunnel info - curl http://localhost:2000/quicktunnel
+type cloudflaredQuickTunnel struct {
+	Hostname string `json:"hostname"`
+}
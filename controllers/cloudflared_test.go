@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"context"
+	stderror "errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// stubAgent serves canned admin API responses (keyed by <port><path>) and container logs. anything else is not found
+type stubAgent struct {
+	responses map[string][]byte
+	errs      map[string]error
+	logs      map[string][]byte
+}
+
+func (a *stubAgent) Get(ctx context.Context, port int, path string) ([]byte, error) {
+	key := fmt.Sprintf("%d%s", port, path)
+	if err, ok := a.errs[key]; ok {
+		return nil, err
+	}
+	if resp, ok := a.responses[key]; ok {
+		return resp, nil
+	}
+	return nil, errors.NewNotFound(schema.GroupResource{Resource: "pods/proxy"}, key)
+}
+
+func (a *stubAgent) Logs(ctx context.Context, container string) ([]byte, error) {
+	if logs, ok := a.logs[container]; ok {
+		return logs, nil
+	}
+	return nil, errors.NewNotFound(schema.GroupResource{Resource: "pods/log"}, container)
+}
+
+func readPayload(t *testing.T, provider, name string) []byte {
+	t.Helper()
+	data, err := ioutil.ReadFile(filepath.Join("testdata", provider, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestCloudflaredDiscoverURLs(t *testing.T) {
+	web := tunnel{name: "web", protocol: "http", address: "nginx-svc-kubexpose-test:80"}
+	admin := tunnel{name: "admin", protocol: "http", address: "nginx-svc-kubexpose-test:8080"}
+
+	tests := []struct {
+		name      string
+		tunnels   []tunnel
+		responses map[string]string
+		logs      map[string]string
+		want      map[string]string
+		wantErr   interface{}
+	}{
+		{
+			name:      "quick tunnel endpoint",
+			tunnels:   []tunnel{web},
+			responses: map[string]string{"2000/quicktunnel": "quicktunnel.json"},
+			want:      map[string]string{"web": "https://ranked-lemon-pumps-gather.trycloudflare.com"},
+		},
+		{
+			name:      "one container per port",
+			tunnels:   []tunnel{web, admin},
+			responses: map[string]string{"2000/quicktunnel": "quicktunnel.json"},
+			logs:      map[string]string{"cloudflared-admin": "quicktunnel.log"},
+			want:      map[string]string{"web": "https://ranked-lemon-pumps-gather.trycloudflare.com", "admin": "https://tender-owl-machines-deluxe.trycloudflare.com"},
+		},
+		{
+			name:      "quick tunnel not created yet",
+			tunnels:   []tunnel{web},
+			responses: map[string]string{"2000/quicktunnel": "quicktunnel_pending.json"},
+			wantErr:   &urlNotReadyError{},
+		},
+		{
+			name:      "truncated response",
+			tunnels:   []tunnel{web},
+			responses: map[string]string{"2000/quicktunnel": "truncated.json"},
+			wantErr:   &urlMalformedError{},
+		},
+		{
+			// older cloudflared versions
+			name:    "log fallback",
+			tunnels: []tunnel{web},
+			logs:    map[string]string{"cloudflared-web": "quicktunnel.log"},
+			want:    map[string]string{"web": "https://tender-owl-machines-deluxe.trycloudflare.com"},
+		},
+		{
+			name:    "log fallback after reconnecting",
+			tunnels: []tunnel{web},
+			logs:    map[string]string{"cloudflared-web": "reconnected.log"},
+			want:    map[string]string{"web": "https://quiet-river-signals-ample.trycloudflare.com"},
+		},
+		{
+			name:    "log fallback before the url is logged",
+			tunnels: []tunnel{web},
+			logs:    map[string]string{"cloudflared-web": "starting.log"},
+			wantErr: &urlNotReadyError{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent := &stubAgent{responses: map[string][]byte{}, logs: map[string][]byte{}}
+			for key, payload := range tt.responses {
+				agent.responses[key] = readPayload(t, "cloudflared", payload)
+			}
+			for container, payload := range tt.logs {
+				agent.logs[container] = readPayload(t, "cloudflared", payload)
+			}
+
+			urls, err := cloudflaredProvider{}.DiscoverURLs(context.Background(), agent, tunnelConfig{tunnels: tt.tunnels})
+
+			if tt.wantErr != nil {
+				if !stderror.As(err, tt.wantErr) {
+					t.Fatalf("expected %T, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if !reflect.DeepEqual(urls, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, urls)
+			}
+		})
+	}
+}
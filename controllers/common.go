@@ -1,300 +1,576 @@
 package controllers
 
 import (
-	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"net/http"
+	"sort"
 	"strconv"
-	"strings"
+	"time"
 
 	stderror "errors"
 
 	kubexposev1 "github.com/abhirockzz/kubexpose-operator/api/v1"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
-	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/apimachinery/pkg/runtime/serializer"
 	"k8s.io/apimachinery/pkg/selection"
-	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/client/config"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
-// createService creates a Service (type ClusterIP) for the Deployment to be accessed
-func (r *KubexposeReconciler) createService(ctx context.Context, req ctrl.Request, kexp *kubexposev1.Kubexpose) (ctrl.Result, error) {
+// getSource looks up the workload (or Service) to be exposed. nil is returned if it does not exist
+func (r *KubexposeReconciler) getSource(ctx context.Context, req ctrl.Request, kexp *kubexposev1.Kubexpose) (*exposedSource, error) {
 	logger := log.Log.WithValues("kubexpose", req.NamespacedName)
 
-	namespace := kexp.Spec.TargetNamespace
+	namespace := kexp.ExposedNamespace()
+	ref := kexp.Spec.SourceRef()
 
-	logger.Info("looking for source deployment", "namespace", namespace, "name", kexp.Spec.SourceDeploymentName)
+	logger.Info("looking for source", "namespace", namespace, "kind", ref.Kind, "name", ref.Name)
 
-	// we need the Deployment labels to create the Service
-	var sourceDeployment appsv1.Deployment
-	err := r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: kexp.Spec.SourceDeploymentName}, &sourceDeployment)
+	// we need the labels of the source Pods to create the Service
+	source, err := r.fetchSource(ctx, kexp)
 
 	if err != nil {
 		if errors.IsNotFound(err) {
-			logger.Error(err, "source deployment does not exist", "namespace", namespace, "name", kexp.Spec.SourceDeploymentName)
-		} else {
-			logger.Error(err, "error finding source deployment", "namespace", namespace, "name", kexp.Spec.SourceDeploymentName)
+			logger.Error(err, "source does not exist", "namespace", namespace, "kind", ref.Kind, "name", ref.Name)
+			message := fmt.Sprintf("%s %s/%s does not exist", ref.Kind, namespace, ref.Name)
+			r.recordTransition(kexp, kubexposev1.ConditionSourceFound, reasonSourceNotFound, corev1.EventTypeWarning, eventSourceNotFound, message)
+			setCondition(kexp, kubexposev1.ConditionSourceFound, metaV1.ConditionFalse, reasonSourceNotFound, message)
+			return nil, nil
 		}
 
-		// can't do much here. do not requeue
-		return ctrl.Result{}, nil
+		if isPermanent(err) {
+			logger.Error(err, "invalid source", "namespace", namespace, "kind", ref.Kind, "name", ref.Name)
+			r.recordTransition(kexp, kubexposev1.ConditionSourceFound, reasonInvalidSource, corev1.EventTypeWarning, eventInvalidSource, err.Error())
+			setCondition(kexp, kubexposev1.ConditionSourceFound, metaV1.ConditionFalse, reasonInvalidSource, err.Error())
+			return nil, nil
+		}
+
+		logger.Error(err, "error finding source", "namespace", namespace, "kind", ref.Kind, "name", ref.Name)
+		setCondition(kexp, kubexposev1.ConditionSourceFound, metaV1.ConditionUnknown, reasonSourceLookupFailed, err.Error())
+		return nil, err
 	}
 
-	logger.Info("found source deployment", "namespace", namespace, "name", kexp.Spec.SourceDeploymentName)
+	logger.Info("found source", "namespace", namespace, "kind", ref.Kind, "name", ref.Name)
+	setCondition(kexp, kubexposev1.ConditionSourceFound, metaV1.ConditionTrue, reasonSourceFound, "")
+
+	return source, nil
+}
+
+// reconcileService creates a Service (type ClusterIP) for the source to be accessed.
+// if the Service exists, it's updated in case its selector or ports do not match the source and Kubexpose spec.
+// if the source is a Service, it's used as is
+func (r *KubexposeReconciler) reconcileService(ctx context.Context, req ctrl.Request, kexp *kubexposev1.Kubexpose, source *exposedSource) (controllerutil.OperationResult, error) {
+	logger := log.Log.WithValues("kubexpose", req.NamespacedName)
 
-	serviceName := fmt.Sprintf(serviceNameFormat, sourceDeployment.Name, kexp.Name)
-	selector := sourceDeployment.Spec.Selector.MatchLabels
+	portList := kexp.Spec.PortList()
+	for _, port := range portList {
+		if port.Port == 0 {
+			err := permanentError{msg: "port to expose is not specified"}
+			setCondition(kexp, kubexposev1.ConditionServiceReady, metaV1.ConditionFalse, reasonServicePortNotFound, err.Error())
+			return controllerutil.OperationResultNone, err
+		}
+	}
+
+	if source.existingService() {
+		for _, port := range portList {
+			if !hasServicePort(source.servicePorts, port.Port) {
+				err := permanentError{msg: fmt.Sprintf("service %s does not have port %d", source.name, port.Port)}
+				setCondition(kexp, kubexposev1.ConditionServiceReady, metaV1.ConditionFalse, reasonServicePortNotFound, err.Error())
+				return controllerutil.OperationResultNone, err
+			}
+		}
+
+		setCondition(kexp, kubexposev1.ConditionServiceReady, metaV1.ConditionTrue, reasonExistingService, "")
+		return controllerutil.OperationResultNone, nil
+	}
+
+	serviceName := serviceNameFor(kexp)
+	selector := source.selector
+
+	var ports []corev1.ServicePort
+	for _, port := range portList {
+		targetPort := intstr.FromInt(int(port.Port))
+		if port.TargetPort != nil {
+			targetPort = *port.TargetPort
+		}
+
+		ports = append(ports, corev1.ServicePort{
+			Name:       port.Name,
+			Protocol:   corev1.ProtocolTCP,
+			Port:       port.Port,
+			TargetPort: targetPort,
+		})
+	}
 
 	svc := &corev1.Service{
 		ObjectMeta: metaV1.ObjectMeta{
 			Name:      serviceName,
-			Namespace: namespace,
-		},
-		Spec: corev1.ServiceSpec{
-			Selector: selector,
-			Ports: []corev1.ServicePort{
-				{
-					Port: int32(kexp.Spec.PortToExpose),
-				},
-			},
+			Namespace: kexp.ExposedNamespace(),
 		},
 	}
-	// Set Kubexpose instance as the owner and controller
-	err = ctrl.SetControllerReference(kexp, svc, r.Scheme)
+
+	op, err := controllerutil.CreateOrUpdate(ctx, r.Client, svc, func() error {
+		// the ports are replaced as a whole so that removed ones are dropped. nothing is defaulted in the ports
+		// of a ClusterIP Service, hence an unchanged Service is not updated
+		svc.Spec.Selector = selector
+		svc.Spec.Ports = ports
+
+		return r.setOwnership(kexp, svc)
+	})
 
 	if err != nil {
-		logger.Error(err, "error setting controller reference", "namespace", svc.Namespace, "name", svc.Name)
-		return ctrl.Result{}, err
+		logger.Error(err, "failed to create or update service", "namespace", svc.Namespace, "name", svc.Name)
+		setCondition(kexp, kubexposev1.ConditionServiceReady, metaV1.ConditionFalse, reasonServiceFailed, err.Error())
+		return op, err
 	}
 
-	logger.Info("initiating service creation", "namespace", svc.Namespace, "name", svc.Name)
-
-	err = r.Create(ctx, svc)
+	if op != controllerutil.OperationResultNone {
+		logger.Info("service successfully "+string(op), "namespace", svc.Namespace, "name", svc.Name)
+	}
 
-	if err != nil {
-		logger.Error(err, "failed to create service", "namespace", svc.Namespace, "name", svc.Name)
-		return ctrl.Result{}, err
+	reason := reasonServiceAvailable
+	if op == controllerutil.OperationResultCreated {
+		reason = reasonServiceCreated
+		r.Recorder.Eventf(kexp, corev1.EventTypeNormal, eventServiceCreated, "created service %s/%s", svc.Namespace, svc.Name)
 	}
+	setCondition(kexp, kubexposev1.ConditionServiceReady, metaV1.ConditionTrue, reason, "")
+
+	return op, nil
+}
 
-	logger.Info("service successfully created", "namespace", svc.Namespace, "name", svc.Name)
+func hasServicePort(ports []corev1.ServicePort, port int32) bool {
+	for _, p := range ports {
+		if p.Port == port {
+			return true
+		}
+	}
+	return false
+}
 
-	// service created successfully - return and requeue
-	return ctrl.Result{Requeue: true}, nil
+// tunnelConfigFor returns the tunnels to be run for the ports in the Kubexpose spec
+func tunnelConfigFor(kexp *kubexposev1.Kubexpose) tunnelConfig {
+	serviceName := serviceNameFor(kexp)
+
+	cfg := tunnelConfig{configMapName: configMapNameFor(kexp), region: kexp.Spec.Region}
+	for i, port := range kexp.Spec.PortList() {
+		serviceAddress := serviceName + ":" + strconv.Itoa(int(port.Port))
+
+		cfg.tunnels = append(cfg.tunnels, tunnel{
+			name:           port.Name,
+			protocol:       port.Protocol,
+			address:        tunnelAddress(kexp, i, port, serviceAddress),
+			serviceAddress: serviceAddress,
+			hostname:       port.Hostname,
+			subdomain:      port.Subdomain,
+		})
+	}
+	return cfg
 }
 
-// createDeployment creates a ngrok Deployment
-func (r *KubexposeReconciler) createDeployment(ctx context.Context, req ctrl.Request, kexp *kubexposev1.Kubexpose) (ctrl.Result, error) {
+// reconcileConfigMap creates (or updates) the ConfigMap with the tunnel provider (and access proxy) configuration.
+// the hash of the configuration is returned - it's used to restart the tunnel Pods when the configuration changes.
+// the ConfigMap is deleted if it's not needed
+func (r *KubexposeReconciler) reconcileConfigMap(ctx context.Context, req ctrl.Request, kexp *kubexposev1.Kubexpose, provider TunnelProvider, cfg tunnelConfig) (string, error) {
 	logger := log.Log.WithValues("kubexpose", req.NamespacedName)
-	namespace := kexp.Spec.TargetNamespace
-
-	deploymentName := fmt.Sprintf(deploymentNameFormat, kexp.Spec.SourceDeploymentName, kexp.Name)
 
-	numReplicas := int32(1)
-	serviceName := fmt.Sprintf(serviceNameFormat, kexp.Spec.SourceDeploymentName, kexp.Name)
+	data, err := provider.ConfigData(kexp, cfg)
+	if err != nil {
+		return "", err
+	}
 
-	ngrokPort := int32(4040)
+	if accessData := accessProxyConfigData(kexp, cfg); accessData != nil {
+		if data == nil {
+			data = map[string]string{}
+		}
+		for k, v := range accessData {
+			data[k] = v
+		}
+	}
 
-	dep := &appsv1.Deployment{
+	cm := &corev1.ConfigMap{
 		ObjectMeta: metaV1.ObjectMeta{
-			Name:      deploymentName,
-			Namespace: namespace,
-		},
-		Spec: appsv1.DeploymentSpec{
-			Replicas: &numReplicas,
-			Selector: &metaV1.LabelSelector{
-				MatchLabels: map[string]string{
-					"exposing":     kexp.Spec.SourceDeploymentName,
-					"kubexpose-cr": kexp.Name,
-				},
-			},
-			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metaV1.ObjectMeta{
-					Labels: map[string]string{
-						"exposing":     kexp.Spec.SourceDeploymentName,
-						"kubexpose-cr": kexp.Name,
-					},
-				},
-				Spec: corev1.PodSpec{
-					Containers: []corev1.Container{
-						{
-							Name:    "ngrok",
-							Image:   "wernight/ngrok",
-							Command: []string{"ngrok"},
-							Args:    []string{"http", serviceName + ":" + strconv.Itoa(kexp.Spec.PortToExpose)},
-							Ports:   []corev1.ContainerPort{{ContainerPort: ngrokPort}},
-						},
-					},
-				},
-			},
+			Name:      cfg.configMapName,
+			Namespace: kexp.ExposedNamespace(),
 		},
 	}
 
-	// Set Kubexpose instance as the owner and controller
-	err := ctrl.SetControllerReference(kexp, dep, r.Scheme)
+	if data == nil {
+		err = r.Delete(ctx, cm)
+		return "", client.IgnoreNotFound(err)
+	}
+
+	op, err := controllerutil.CreateOrUpdate(ctx, r.Client, cm, func() error {
+		cm.Data = data
+		return r.setOwnership(kexp, cm)
+	})
 
 	if err != nil {
-		logger.Error(err, "error setting controller reference", "namespace", dep.Namespace, "name", dep.Name)
-		return ctrl.Result{}, err
+		logger.Error(err, "failed to create or update configmap", "namespace", cm.Namespace, "name", cm.Name)
+		return "", err
 	}
 
-	logger.Info("initiating new deployment creation", "namespace", dep.Namespace, "name", dep.Name)
+	if op != controllerutil.OperationResultNone {
+		logger.Info("configmap successfully "+string(op), "namespace", cm.Namespace, "name", cm.Name)
+	}
 
-	err = r.Create(ctx, dep)
+	return configHash(data), nil
+}
 
-	if err != nil {
-		logger.Error(err, "failed to create deployment", "namespace", dep.Namespace, "name", dep.Name)
-		return ctrl.Result{}, err
+// configHash returns a stable hash of the ConfigMap data
+func configHash(data map[string]string) string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
 	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write([]byte(data[k]))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
 
-	logger.Info("deployment created successfully", "namespace", dep.Namespace, "name", dep.Name)
-
-	// Deployment created successfully - return and requeue
-	return ctrl.Result{Requeue: true}, nil
+// templateHashFor returns a stable hash of the tunnel Pod template
+func templateHashFor(template corev1.PodTemplateSpec) (string, error) {
+	data, err := json.Marshal(template)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
 }
 
-func (r *KubexposeReconciler) getURL(ctx context.Context, req ctrl.Request, kexp *kubexposev1.Kubexpose) (string, error) {
+// reconcileDeployment creates the tunnel Deployment using the provider configured in the Kubexpose resource.
+// if the Deployment exists, its Pod template is updated in case it does not match the Kubexpose spec (e.g. different ports or provider)
+func (r *KubexposeReconciler) reconcileDeployment(ctx context.Context, req ctrl.Request, kexp *kubexposev1.Kubexpose) (controllerutil.OperationResult, error) {
 	logger := log.Log.WithValues("kubexpose", req.NamespacedName)
 
-	logger.Info("fetching url at which deployment will be accessible")
-
-	cfg, err := config.GetConfig()
+	provider, err := providerFor(kexp)
 	if err != nil {
-		//logger.Error(err, "failed to get rest config")
-		return "", err
+		return controllerutil.OperationResultNone, err
 	}
 
-	cfg.APIPath = "/api"
-	cfg.GroupVersion = &schema.GroupVersion{Group: "", Version: "v1"}
-	cfg.NegotiatedSerializer = serializer.WithoutConversionCodecFactory{}
+	cfg := tunnelConfigFor(kexp)
 
-	var pods corev1.PodList
-	selectorLabels := "exposing=" + kexp.Spec.SourceDeploymentName + ",kubexpose-cr=" + kexp.Name
+	authToken, authHash, err := r.getAuthToken(ctx, kexp)
+	if err != nil {
+		logger.Error(err, "failed to get authtoken")
+		setCondition(kexp, kubexposev1.ConditionTunnelReady, metaV1.ConditionFalse, reasonAuthSecretInvalid, err.Error())
+		return controllerutil.OperationResultNone, err
+	}
+	cfg.authToken = authToken
 
-	//logger.Info("looking for pod", "labels", selectorLabels)
+	// the provider may need the authtoken, e.g. for a reserved hostname
+	err = provider.Validate(kexp, cfg)
+	if err != nil {
+		logger.Error(err, "provider can't run the tunnels", "provider", provider.Name())
+		setCondition(kexp, kubexposev1.ConditionTunnelReady, metaV1.ConditionFalse, reasonUnsupportedTunnel, err.Error())
+		return controllerutil.OperationResultNone, err
+	}
 
-	r1, _ := labels.NewRequirement("exposing", selection.Equals, []string{kexp.Spec.SourceDeploymentName})
-	r2, _ := labels.NewRequirement("kubexpose-cr", selection.Equals, []string{kexp.Name})
+	err = validateAccess(kexp, cfg)
+	if err != nil {
+		logger.Error(err, "access can't be restricted")
+		setCondition(kexp, kubexposev1.ConditionTunnelReady, metaV1.ConditionFalse, reasonInvalidAccess, err.Error())
+		return controllerutil.OperationResultNone, err
+	}
 
-	err = r.List(ctx, &pods, &client.ListOptions{LabelSelector: labels.NewSelector().Add(*r1, *r2), Namespace: kexp.Spec.TargetNamespace})
+	accessHash, err := r.getAccessHash(ctx, kexp)
+	if err != nil {
+		logger.Error(err, "failed to get secrets to restrict access")
+		setCondition(kexp, kubexposev1.ConditionTunnelReady, metaV1.ConditionFalse, reasonAccessSecretInvalid, err.Error())
+		return controllerutil.OperationResultNone, err
+	}
 
+	hash, err := r.reconcileConfigMap(ctx, req, kexp, provider, cfg)
 	if err != nil {
-		//logger.Error(err, "failed to list pods")
-		return "", err
+		setCondition(kexp, kubexposev1.ConditionTunnelReady, metaV1.ConditionFalse, reasonDeploymentFailed, err.Error())
+		return controllerutil.OperationResultNone, err
+	}
+
+	deploymentName := deploymentNameFor(kexp)
+
+	numReplicas := int32(1)
+
+	podLabels := map[string]string{
+		"exposing":     labelValue(kexp.Spec.SourceRef().Name),
+		"kubexpose-cr": labelValue(kexp.Name),
 	}
 
-	if len(pods.Items) == 0 {
-		//logger.Info("no pods found")
-		return "", stderror.New("no pods found")
+	template := corev1.PodTemplateSpec{
+		ObjectMeta: metaV1.ObjectMeta{
+			Labels: podLabels,
+		},
+		Spec: provider.PodSpec(kexp, cfg),
 	}
+	accessProxyPodSpec(kexp, cfg, &template.Spec)
 
-	// we expect to get ONE pod only. there might be a situation when a Pod with same label might be terminating. we want retry in this case
-	if len(pods.Items) > 1 {
-		//logger.Info("multiple pods found!", "labels", selectorLabels)
-		return "", stderror.New("multiple pods found for label - " + selectorLabels)
+	// the tunnel Pods are restarted if the configuration, authtoken or Secrets used to restrict access change
+	annotations := map[string]string{}
+	if hash != "" {
+		annotations[configHashAnnotation] = hash
+	}
+	if authHash != "" {
+		annotations[authHashAnnotation] = authHash
+	}
+	if accessHash != "" {
+		annotations[accessHashAnnotation] = accessHash
+	}
+	if len(annotations) > 0 {
+		template.Annotations = annotations
 	}
 
-	podName := pods.Items[0].Name
+	templateHash, err := templateHashFor(template)
+	if err != nil {
+		return controllerutil.OperationResultNone, err
+	}
 
-	//logger.Info("found pod", "Name", podName)
+	dep := &appsv1.Deployment{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      deploymentName,
+			Namespace: kexp.ExposedNamespace(),
+		},
+	}
+
+	op, err := controllerutil.CreateOrUpdate(ctx, r.Client, dep, func() error {
+		// selector is immutable
+		if dep.CreationTimestamp.IsZero() {
+			dep.Spec.Selector = &metaV1.LabelSelector{MatchLabels: podLabels}
+		}
+		dep.Spec.Replicas = &numReplicas
+
+		// the API server defaults fields of the template e.g. image pull policy, hence it can't be compared as is.
+		// the hash of the template detects changes, including removed fields (e.g. the authtoken), while
+		// DeepDerivative catches edits made to the Deployment
+		if dep.Annotations[templateHashAnnotation] != templateHash || !equality.Semantic.DeepDerivative(template, dep.Spec.Template) {
+			dep.Spec.Template = template
+		}
+
+		err := r.setOwnership(kexp, dep)
+		if err != nil {
+			return err
+		}
+		dep.Annotations[templateHashAnnotation] = templateHash
+		return nil
+	})
 
-	restClient, err := rest.RESTClientFor(cfg)
 	if err != nil {
-		//logger.Error(err, "failed to get rest client")
-		return "", err
+		logger.Error(err, "failed to create or update deployment", "namespace", dep.Namespace, "name", dep.Name)
+		setCondition(kexp, kubexposev1.ConditionTunnelReady, metaV1.ConditionFalse, reasonDeploymentFailed, err.Error())
+		return op, err
 	}
 
-	namespace := kexp.Spec.TargetNamespace
+	if op != controllerutil.OperationResultNone {
+		logger.Info("deployment successfully "+string(op), "namespace", dep.Namespace, "name", dep.Name, "provider", provider.Name())
+
+		reason := reasonDeploymentUpdated
+		if op == controllerutil.OperationResultCreated {
+			reason = reasonDeploymentCreated
+			r.Recorder.Eventf(kexp, corev1.EventTypeNormal, eventTunnelCreated, "created %s tunnel deployment %s/%s", provider.Name(), dep.Namespace, dep.Name)
+		}
+		setCondition(kexp, kubexposev1.ConditionTunnelReady, metaV1.ConditionFalse, reason, "waiting for the tunnel to start")
+		// the tunnel Pod will be (re)created and the public url is going to change
+		setCondition(kexp, kubexposev1.ConditionURLAvailable, metaV1.ConditionFalse, reasonURLDiscoveryPending, "waiting for the tunnel to start")
+	}
+
+	return op, nil
+}
 
-	execReq := restClient.Post().
-		Namespace(namespace).
-		Resource("pods").
-		Name(podName).
-		SubResource("exec").
-		VersionedParams(&corev1.PodExecOptions{
-			Container: "ngrok",
-			Command:   []string{"curl", "http://localhost:4040/api/tunnels"},
-			//Stdin:     true,
-			Stdout: true,
-			Stderr: true,
-			TTY:    false,
-		}, runtime.NewParameterCodec(r.Scheme))
+// getURLs asks the tunnel provider for the public url of each port at which the source is accessible, along with the name of the tunnel Pod.
+// the TunnelReady and URLAvailable conditions are updated along the way
+func (r *KubexposeReconciler) getURLs(ctx context.Context, req ctrl.Request, kexp *kubexposev1.Kubexpose) ([]kubexposev1.PortURL, string, error) {
+	logger := log.Log.WithValues("kubexpose", req.NamespacedName)
 
-	executor, err := remotecommand.NewSPDYExecutor(cfg, http.MethodPost, execReq.URL())
+	logger.Info("fetching urls at which source will be accessible")
 
+	provider, err := providerFor(kexp)
 	if err != nil {
-		//logger.Error(err, "failed to create command executor")
-		return "", err
+		setCondition(kexp, kubexposev1.ConditionTunnelReady, metaV1.ConditionFalse, reasonInvalidProvider, err.Error())
+		return nil, "", err
 	}
 
-	logger.Info("initiating 'exec' request", "url", execReq.URL())
+	pod, err := r.getTunnelPod(ctx, kexp)
+	if err != nil {
+		tunnelNotReady(kexp, reasonTunnelPodNotReady, err.Error())
+		urlDiscoveryFailures.WithLabelValues(reasonTunnelPodNotReady).Inc()
+		return nil, "", err
+	}
 
-	var stdout, stderr bytes.Buffer
+	if problem := podProblem(pod); problem != "" {
+		reason := reasonTunnelPodNotReady
+		if !podStarting(pod) {
+			// e.g. CrashLoopBackOff
+			reason = reasonTunnelPodFailing
+			r.recordTransition(kexp, kubexposev1.ConditionTunnelReady, reason, corev1.EventTypeWarning, eventTunnelFailed, "tunnel pod "+pod.Name+" is not running: "+problem)
+		}
+		tunnelNotReady(kexp, reason, problem)
+		urlDiscoveryFailures.WithLabelValues(reason).Inc()
+		return nil, "", stderror.New(problem)
+	}
 
-	err = executor.Stream(remotecommand.StreamOptions{
-		Stdin:  nil,
-		Stdout: &stdout,
-		Stderr: &stderr,
-	})
+	// the health, urls and traffic are typically looked up using the same admin API endpoint - it's queried once
+	agent := &cachingAgent{tunnelAgent: &podAgent{reconciler: r, pod: pod}}
+	cfg := tunnelConfigFor(kexp)
 
+	err = provider.HealthCheck(ctx, agent, cfg)
 	if err != nil {
-		//logger.Error(err, "failed to exec command")
-		if strings.Contains(err.Error(), "container not found") {
-			// ngrok container is not ready. give it a while
-			return "", err
+		r.recordTransition(kexp, kubexposev1.ConditionTunnelReady, reasonTunnelUnhealthy, corev1.EventTypeWarning, eventTunnelFailed, "tunnel is not healthy: "+err.Error())
+		tunnelNotReady(kexp, reasonTunnelUnhealthy, err.Error())
+		urlDiscoveryFailures.WithLabelValues(reasonTunnelUnhealthy).Inc()
+		return nil, "", err
+	}
+	setCondition(kexp, kubexposev1.ConditionTunnelReady, metaV1.ConditionTrue, reasonTunnelHealthy, "")
+
+	start := time.Now()
+	discovered, err := provider.DiscoverURLs(ctx, agent, cfg)
+	urlDiscoveryDuration.WithLabelValues(provider.Name()).Observe(time.Since(start).Seconds())
+	if err != nil {
+		r.discoveryFailed(kexp, err)
+		return nil, "", err
+	}
+
+	// the urls are listed in the same order as the ports. the status is only updated if all of them are found -
+	// a url which is known to work is never replaced with an empty one
+	var urls []kubexposev1.PortURL
+	for _, t := range cfg.tunnels {
+		url := discovered[t.name]
+		if url == "" {
+			err = urlNotReadyError{msg: fmt.Sprintf("url for port %s not found", t.name)}
+			r.discoveryFailed(kexp, err)
+			return nil, "", err
 		}
-		return "", err
+		urls = append(urls, kubexposev1.PortURL{Name: t.name, URL: url})
+		logger.Info("public url - "+url, "port", t.name)
 	}
 
-	if stdout.Bytes() == nil {
-		//logger.Error(err, "no response from command")
-		return "", err
+	setCondition(kexp, kubexposev1.ConditionURLAvailable, metaV1.ConditionTrue, reasonURLDiscovered, "public url is "+urls[0].URL)
+	r.checkReservedNames(kexp, cfg.tunnels, urls)
+	r.refreshTraffic(ctx, kexp, provider, agent, cfg)
+
+	return urls, pod.Name, nil
+}
+
+// tunnelNotReady updates the TunnelReady and URLAvailable conditions if the tunnel is not running or unhealthy. the public url
+// is kept in the status until the tunnel recovers and it's looked up again - it's just not reachable in the meantime
+func tunnelNotReady(kexp *kubexposev1.Kubexpose, reason, message string) {
+	setCondition(kexp, kubexposev1.ConditionTunnelReady, metaV1.ConditionFalse, reason, message)
+	setCondition(kexp, kubexposev1.ConditionURLAvailable, metaV1.ConditionFalse, reason, message)
+}
+
+// discoveryFailed updates the URLAvailable condition as per the error returned by the tunnel provider.
+// a Warning Event is recorded unless the tunnel is just not ready yet
+func (r *KubexposeReconciler) discoveryFailed(kexp *kubexposev1.Kubexpose, err error) {
+	reason := reasonURLDiscoveryFailed
+
+	var notReadyErr urlNotReadyError
+	var malformedErr urlMalformedError
+	var ambiguousErr urlAmbiguousError
+	switch {
+	case stderror.As(err, &notReadyErr):
+		reason = reasonURLDiscoveryPending
+	case stderror.As(err, &malformedErr):
+		reason = reasonURLMalformed
+	case stderror.As(err, &ambiguousErr):
+		reason = reasonURLAmbiguous
 	}
 
-	var ngrokInfo NgrokInfo
-	err = json.Unmarshal(stdout.Bytes(), &ngrokInfo)
+	if reason != reasonURLDiscoveryPending {
+		r.recordTransition(kexp, kubexposev1.ConditionURLAvailable, reason, corev1.EventTypeWarning, eventDiscoveryFailed, err.Error())
+	}
+	setCondition(kexp, kubexposev1.ConditionURLAvailable, metaV1.ConditionFalse, reason, err.Error())
+	urlDiscoveryFailures.WithLabelValues(reason).Inc()
+}
+
+// getTunnelPod finds the (single) Pod of the tunnel Deployment
+func (r *KubexposeReconciler) getTunnelPod(ctx context.Context, kexp *kubexposev1.Kubexpose) (*corev1.Pod, error) {
+	pods, err := r.listTunnelPods(ctx, kexp)
 
 	if err != nil {
-		//logger.Error(err, "ngrok info unmarshal failed")
-		return "", nil
+		return nil, err
 	}
 
-	// ngrok container is not ready. give it a while
-	if len(ngrokInfo.Tunnels) == 0 {
-		return "", stderror.New("ngrok container is not ready")
+	if len(pods) == 0 {
+		return nil, stderror.New("no pods found")
 	}
 
-	var url string
+	// we expect to get ONE pod only. there might be a situation when a Pod with same label might be terminating. we want retry in this case
+	if len(pods) > 1 {
+		return nil, stderror.New("multiple pods found for label - " + "exposing=" + kexp.Spec.SourceRef().Name + ",kubexpose-cr=" + kexp.Name)
+	}
+
+	return &pods[0], nil
+}
+
+// listTunnelPods lists the Pods of the tunnel Deployment, including the ones which are terminating
+func (r *KubexposeReconciler) listTunnelPods(ctx context.Context, kexp *kubexposev1.Kubexpose) ([]corev1.Pod, error) {
+	var pods corev1.PodList
+	sourceName := kexp.Spec.SourceRef().Name
+
+	r1, _ := labels.NewRequirement("exposing", selection.Equals, []string{labelValue(sourceName)})
+	r2, _ := labels.NewRequirement("kubexpose-cr", selection.Equals, []string{labelValue(kexp.Name)})
 
-	// we only need https url
-	if ngrokInfo.Tunnels[0].Proto == "https" {
-		url = ngrokInfo.Tunnels[0].PublicURL
-	} else {
-		url = ngrokInfo.Tunnels[1].PublicURL
+	err := r.List(ctx, &pods, &client.ListOptions{LabelSelector: labels.NewSelector().Add(*r1, *r2), Namespace: kexp.ExposedNamespace()})
+	if err != nil {
+		return nil, err
 	}
-	logger.Info("public url - " + url)
-	return url, nil
 
+	return pods.Items, nil
+}
+
+// podAgent reaches the tunnel container of a Pod. the admin API is accessed over the network using the API server Pod proxy,
+// hence there is no need for a shell or curl in the tunnel image
+type podAgent struct {
+	reconciler *KubexposeReconciler
+	pod        *corev1.Pod
+}
+
+func (a *podAgent) Get(ctx context.Context, port int, path string) ([]byte, error) {
+	logger := log.Log.WithValues("pod", a.pod.Name)
+	logger.Info("querying tunnel admin api", "port", port, "path", path)
+
+	return a.reconciler.Clientset.CoreV1().Pods(a.pod.Namespace).ProxyGet("http", a.pod.Name, strconv.Itoa(port), path, nil).DoRaw(ctx)
+}
+
+func (a *podAgent) Logs(ctx context.Context, container string) ([]byte, error) {
+	return a.reconciler.Clientset.CoreV1().Pods(a.pod.Namespace).GetLogs(a.pod.Name, &corev1.PodLogOptions{Container: container}).DoRaw(ctx)
+}
+
+// cachingAgent remembers the successful responses of the admin API for the duration of a reconciliation
+type cachingAgent struct {
+	tunnelAgent
+	responses map[string][]byte
 }
 
-// json response for ngrok info - curl http://localhost:4040/tunnels
-type NgrokInfo struct {
-	Tunnels []struct {
-		PublicURL string `json:"public_url"`
-		Proto     string `json:"proto"`
-	} `json:"tunnels"`
+func (a *cachingAgent) Get(ctx context.Context, port int, path string) ([]byte, error) {
+	key := fmt.Sprintf("%d%s", port, path)
+	if resp, ok := a.responses[key]; ok {
+		return resp, nil
+	}
+
+	resp, err := a.tunnelAgent.Get(ctx, port, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.responses == nil {
+		a.responses = map[string][]byte{}
+	}
+	a.responses[key] = resp
+	return resp, nil
 }
 
 func (r *KubexposeReconciler) updateStatus(ctx context.Context, req ctrl.Request, kexp *kubexposev1.Kubexpose) (ctrl.Result, error) {
@@ -302,11 +578,11 @@ func (r *KubexposeReconciler) updateStatus(ctx context.Context, req ctrl.Request
 
 	err := r.Status().Update(ctx, kexp)
 	if err != nil {
-		logger.Error(err, "failed to update  status with public url info")
+		logger.Error(err, "failed to update status", "phase", kexp.Status.Phase)
 		return ctrl.Result{}, err
 	}
 
-	logger.Info(kexp.Name + " status updated with public url info")
+	logger.Info(kexp.Name+" status updated", "phase", kexp.Status.Phase, "url", kexp.Status.PublicURL)
 
 	return ctrl.Result{}, nil
 }
@@ -0,0 +1,272 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	kubexposev1 "github.com/abhirockzz/kubexpose-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// reconcileExposed creates or updates the Service and tunnel Deployment for the Kubexpose resource, as Reconcile does
+func reconcileExposed(t *testing.T, r *KubexposeReconciler, kexp *kubexposev1.Kubexpose) (svcOp, depOp controllerutil.OperationResult) {
+	t.Helper()
+	ctx := context.Background()
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: kexp.Namespace, Name: kexp.Name}}
+	source := &exposedSource{kind: kubexposev1.SourceKindDeployment, name: "nginx", selector: map[string]string{"app": "nginx"}}
+
+	svcOp, err := r.reconcileService(ctx, req, kexp, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	depOp, err = r.reconcileDeployment(ctx, req, kexp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return svcOp, depOp
+}
+
+func TestReconcileRemovals(t *testing.T) {
+	kexp := &kubexposev1.Kubexpose{
+		ObjectMeta: metaV1.ObjectMeta{Namespace: "default", Name: "app", UID: "1234"},
+		Spec: kubexposev1.KubexposeSpec{
+			SourceDeploymentName: "nginx",
+			Ports: []kubexposev1.PortSpec{
+				{Name: "web", Port: 80},
+				{Name: "metrics", Port: 9090},
+			},
+			AuthSecretRef: &kubexposev1.SecretKeyReference{Name: "ngrok"},
+		},
+	}
+	secret := &corev1.Secret{ObjectMeta: metaV1.ObjectMeta{Namespace: "default", Name: "ngrok"}, Data: map[string][]byte{"authtoken": []byte("token-1")}}
+
+	ctx := context.Background()
+	r := &KubexposeReconciler{
+		Client:   fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(secret).Build(),
+		Scheme:   testScheme(t),
+		Recorder: record.NewFakeRecorder(10),
+	}
+
+	svcOp, depOp := reconcileExposed(t, r, kexp)
+	if svcOp != controllerutil.OperationResultCreated || depOp != controllerutil.OperationResultCreated {
+		t.Fatalf("service %s, deployment %s, want both created", svcOp, depOp)
+	}
+
+	svcOp, depOp = reconcileExposed(t, r, kexp)
+	if svcOp != controllerutil.OperationResultNone || depOp != controllerutil.OperationResultNone {
+		t.Errorf("unchanged spec: service %s, deployment %s, want both unchanged", svcOp, depOp)
+	}
+
+	kexp.Spec.Ports = kexp.Spec.Ports[:1]
+	kexp.Spec.AuthSecretRef = nil
+
+	svcOp, depOp = reconcileExposed(t, r, kexp)
+	if svcOp != controllerutil.OperationResultUpdated || depOp != controllerutil.OperationResultUpdated {
+		t.Fatalf("service %s, deployment %s, want both updated", svcOp, depOp)
+	}
+
+	var svc corev1.Service
+	if err := r.Get(ctx, client.ObjectKey{Namespace: "default", Name: serviceNameFor(kexp)}, &svc); err != nil {
+		t.Fatal(err)
+	}
+	if len(svc.Spec.Ports) != 1 || svc.Spec.Ports[0].Port != 80 {
+		t.Errorf("service ports %v, want only port 80", svc.Spec.Ports)
+	}
+
+	var dep appsv1.Deployment
+	if err := r.Get(ctx, client.ObjectKey{Namespace: "default", Name: deploymentNameFor(kexp)}, &dep); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := dep.Spec.Template.Annotations[authHashAnnotation]; ok {
+		t.Errorf("auth hash annotation not removed: %v", dep.Spec.Template.Annotations)
+	}
+	container := dep.Spec.Template.Spec.Containers[0]
+	if len(container.Env) != 0 {
+		t.Errorf("authtoken env not removed: %v", container.Env)
+	}
+	for _, arg := range container.Args {
+		if arg == "--authtoken" {
+			t.Errorf("authtoken arg not removed: %v", container.Args)
+		}
+	}
+}
+
+func TestReconcileServicePorts(t *testing.T) {
+	adminTarget := intstr.FromString("admin")
+	kexp := &kubexposev1.Kubexpose{
+		ObjectMeta: metaV1.ObjectMeta{Namespace: "default", Name: "app", UID: "1234"},
+		Spec: kubexposev1.KubexposeSpec{
+			SourceDeploymentName: "nginx",
+			Ports: []kubexposev1.PortSpec{
+				{Name: "web", Port: 80},
+				{Name: "metrics", Port: 9090},
+				{Name: "admin", Port: 8443},
+			},
+		},
+	}
+
+	ctx := context.Background()
+	r := &KubexposeReconciler{
+		Client:   fake.NewClientBuilder().WithScheme(testScheme(t)).Build(),
+		Scheme:   testScheme(t),
+		Recorder: record.NewFakeRecorder(10),
+	}
+	reconcileExposed(t, r, kexp)
+
+	kexp.Spec.Ports = []kubexposev1.PortSpec{
+		{Name: "web", Port: 80},
+		{Name: "admin", Port: 8443, TargetPort: &adminTarget},
+	}
+	reconcileExposed(t, r, kexp)
+
+	var svc corev1.Service
+	if err := r.Get(ctx, client.ObjectKey{Namespace: "default", Name: serviceNameFor(kexp)}, &svc); err != nil {
+		t.Fatal(err)
+	}
+	want := []corev1.ServicePort{
+		{Name: "web", Protocol: corev1.ProtocolTCP, Port: 80, TargetPort: intstr.FromInt(80)},
+		{Name: "admin", Protocol: corev1.ProtocolTCP, Port: 8443, TargetPort: adminTarget},
+	}
+	if !reflect.DeepEqual(svc.Spec.Ports, want) {
+		t.Errorf("service ports %v, want %v", svc.Spec.Ports, want)
+	}
+
+	var cm corev1.ConfigMap
+	if err := r.Get(ctx, client.ObjectKey{Namespace: "default", Name: configMapNameFor(kexp)}, &cm); err != nil {
+		t.Fatal(err)
+	}
+	if config := cm.Data[ngrokConfigFile]; strings.Contains(config, "metrics") || !strings.Contains(config, "admin") {
+		t.Errorf("tunnels not updated in the ngrok configuration\n%s", config)
+	}
+}
+
+func TestGetURLsTunnelFailing(t *testing.T) {
+	kexp := &kubexposev1.Kubexpose{
+		ObjectMeta: metaV1.ObjectMeta{Namespace: "default", Name: "app"},
+		Spec:       kubexposev1.KubexposeSpec{SourceDeploymentName: "nginx", PortToExpose: 80, Provider: "stub"},
+		Status: kubexposev1.KubexposeStatus{
+			PublicURL: "https://4b5c1e1f3a2d.ngrok.io",
+			Conditions: []metaV1.Condition{
+				{Type: kubexposev1.ConditionSourceFound, Status: metaV1.ConditionTrue, Reason: reasonSourceFound},
+				{Type: kubexposev1.ConditionTunnelReady, Status: metaV1.ConditionTrue, Reason: reasonTunnelHealthy},
+				{Type: kubexposev1.ConditionURLAvailable, Status: metaV1.ConditionTrue, Reason: reasonURLDiscovered},
+			},
+		},
+	}
+	crashLooping := containerState(corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}})
+	registerProvider(stubProvider{})
+
+	r := &KubexposeReconciler{
+		Client:   fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(tunnelPod(kexp, "tunnel-1", crashLooping)).Build(),
+		Scheme:   testScheme(t),
+		Recorder: record.NewFakeRecorder(10),
+	}
+
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "app"}}
+	if _, _, err := r.getURLs(context.Background(), req, kexp); err == nil {
+		t.Fatal("expected an error for a crashlooping tunnel")
+	}
+
+	for _, conditionType := range []string{kubexposev1.ConditionTunnelReady, kubexposev1.ConditionURLAvailable} {
+		condition := meta.FindStatusCondition(kexp.Status.Conditions, conditionType)
+		if condition.Status != metaV1.ConditionFalse || condition.Reason != reasonTunnelPodFailing {
+			t.Errorf("%s is %s (%s), want False (%s)", conditionType, condition.Status, condition.Reason, reasonTunnelPodFailing)
+		}
+	}
+	if phase := phaseFor(kexp); phase == kubexposev1.PhaseReady {
+		t.Errorf("phase %s for a crashlooping tunnel", phase)
+	}
+	if kexp.Status.PublicURL == "" {
+		t.Error("public url cleared")
+	}
+}
+
+func TestReconcileSourceNotFound(t *testing.T) {
+	newKubexpose := func(targetNamespace string) *kubexposev1.Kubexpose {
+		return &kubexposev1.Kubexpose{
+			ObjectMeta: metaV1.ObjectMeta{Namespace: "default", Name: "app"},
+			Spec: kubexposev1.KubexposeSpec{
+				Source:          &kubexposev1.SourceReference{Kind: kubexposev1.SourceKindDeployment, Name: "nginx"},
+				TargetNamespace: targetNamespace,
+				PortToExpose:    80,
+				Provider:        "stub",
+			},
+			Status: kubexposev1.KubexposeStatus{
+				PublicURL: "https://4b5c1e1f3a2d.ngrok.io",
+				URLs:      []kubexposev1.PortURL{{Name: "http", URL: "https://4b5c1e1f3a2d.ngrok.io"}},
+				Conditions: []metaV1.Condition{
+					{Type: kubexposev1.ConditionSourceFound, Status: metaV1.ConditionTrue, Reason: reasonSourceFound},
+					{Type: kubexposev1.ConditionTunnelReady, Status: metaV1.ConditionTrue, Reason: reasonTunnelHealthy},
+					{Type: kubexposev1.ConditionURLAvailable, Status: metaV1.ConditionTrue, Reason: reasonURLDiscovered},
+				},
+			},
+		}
+	}
+	registerProvider(stubProvider{})
+
+	tests := []struct {
+		name string
+		// the source does not exist in the target namespace
+		targetNamespace string
+		// the tunnel Deployment created for the previous target namespace is deleted
+		deleted bool
+	}{
+		{"source deleted", "default", false},
+		{"target namespace changed", "apps", true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			kexp := newKubexpose(tc.targetNamespace)
+			ctx := context.Background()
+			r := &KubexposeReconciler{
+				Client:   fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(tunnelDeployment(kexp, 1)).Build(),
+				Scheme:   testScheme(t),
+				Recorder: record.NewFakeRecorder(10),
+			}
+
+			req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "app"}}
+			if _, err := r.reconcileResource(ctx, req, kexp, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			var dep appsv1.Deployment
+			err := r.Get(ctx, client.ObjectKey{Namespace: "default", Name: deploymentNameFor(kexp)}, &dep)
+			if tc.deleted {
+				if !errors.IsNotFound(err) {
+					t.Errorf("stale tunnel deployment not deleted: %v", err)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if *dep.Spec.Replicas != 0 {
+					t.Errorf("tunnel deployment has %d replicas, want 0", *dep.Spec.Replicas)
+				}
+			}
+
+			if kexp.Status.PublicURL != "" || len(kexp.Status.URLs) != 0 {
+				t.Errorf("public url %q not cleared", kexp.Status.PublicURL)
+			}
+			if condition := meta.FindStatusCondition(kexp.Status.Conditions, kubexposev1.ConditionURLAvailable); condition.Status != metaV1.ConditionFalse || condition.Reason != reasonSourceNotFound {
+				t.Errorf("URLAvailable is %s (%s), want False (%s)", condition.Status, condition.Reason, reasonSourceNotFound)
+			}
+			if phase := phaseFor(kexp); phase != kubexposev1.PhasePending {
+				t.Errorf("phase %s, want %s", phase, kubexposev1.PhasePending)
+			}
+		})
+	}
+}
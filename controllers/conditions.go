@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"fmt"
+
+	kubexposev1 "github.com/abhirockzz/kubexpose-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// reasons used in the Kubexpose status conditions
+const (
+	reasonSourceFound         = "SourceAvailable"
+	reasonSourceNotFound      = "SourceNotFound"
+	reasonSourceLookupFailed  = "SourceLookupFailed"
+	reasonInvalidSource       = "InvalidSource"
+	reasonServiceCreated      = "ServiceCreated"
+	reasonServiceAvailable    = "ServiceAvailable"
+	reasonExistingService     = "ExistingService"
+	reasonServicePortNotFound = "ServicePortNotFound"
+	reasonServiceFailed       = "ServiceFailed"
+	reasonInvalidProvider     = "InvalidProvider"
+	reasonUnsupportedTunnel   = "UnsupportedTunnel"
+	reasonDeploymentCreated   = "TunnelDeploymentCreated"
+	reasonDeploymentUpdated   = "TunnelDeploymentUpdated"
+	reasonDeploymentFailed    = "TunnelDeploymentFailed"
+	reasonAuthSecretInvalid   = "AuthSecretInvalid"
+	reasonAccessSecretInvalid = "AccessSecretInvalid"
+	reasonInvalidAccess       = "InvalidAccess"
+	reasonTunnelPodNotReady   = "TunnelPodNotReady"
+	reasonTunnelPodFailing    = "TunnelPodFailing"
+	reasonTunnelUnhealthy     = "TunnelUnhealthy"
+	reasonTunnelHealthy       = "TunnelHealthy"
+	reasonURLDiscovered       = "URLDiscovered"
+	reasonURLDiscoveryPending = "URLDiscoveryPending"
+	reasonURLDiscoveryFailed  = "URLDiscoveryFailed"
+	reasonURLMalformed        = "URLMalformed"
+	reasonURLAmbiguous        = "URLAmbiguous"
+	reasonTearingDown         = "TearingDown"
+	reasonNotExpired          = "NotExpired"
+	reasonExpiringSoon        = "ExpiringSoon"
+	reasonExpired             = "Expired"
+	reasonWindowOpen          = "WindowOpen"
+	reasonWindowClosed        = "WindowClosed"
+	reasonInvalidSchedule     = "InvalidSchedule"
+	reasonPolicyCompliant     = "PolicyCompliant"
+	reasonPolicyViolation     = "PolicyViolation"
+	reasonTunnelLimitReached  = "TunnelLimitReached"
+	reasonNameHonoured        = "ReservedNameHonoured"
+	reasonNameNotHonoured     = "ReservedNameNotHonoured"
+	reasonPublished           = "URLPublished"
+	reasonPublishFailed       = "PublishFailed"
+)
+
+// setCondition adds or updates a condition in the Kubexpose status.
+// the transition time is only changed if the condition status changes
+func setCondition(kexp *kubexposev1.Kubexpose, conditionType string, status metaV1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&kexp.Status.Conditions, metaV1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: kexp.Generation,
+	})
+}
+
+// removeCondition removes a condition from the Kubexpose status.
+// meta.RemoveStatusCondition panics if there are no conditions at all, which is the case for a new Kubexpose resource
+func removeCondition(kexp *kubexposev1.Kubexpose, conditionType string) {
+	if len(kexp.Status.Conditions) == 0 {
+		return
+	}
+	meta.RemoveStatusCondition(&kexp.Status.Conditions, conditionType)
+}
+
+// phaseFor summarises the conditions of the Kubexpose resource
+func phaseFor(kexp *kubexposev1.Kubexpose) kubexposev1.KubexposePhase {
+	conditions := kexp.Status.Conditions
+
+	if meta.IsStatusConditionTrue(conditions, kubexposev1.ConditionExpired) {
+		return kubexposev1.PhaseExpired
+	}
+
+	policy := meta.FindStatusCondition(conditions, kubexposev1.ConditionPolicyCompliant)
+	if policy != nil && policy.Reason == reasonPolicyViolation {
+		return kubexposev1.PhaseFailed
+	}
+
+	schedule := meta.FindStatusCondition(conditions, kubexposev1.ConditionWithinSchedule)
+	if schedule != nil && schedule.Reason == reasonInvalidSchedule {
+		return kubexposev1.PhaseFailed
+	}
+	if schedule != nil && schedule.Reason == reasonWindowClosed {
+		return kubexposev1.PhaseIdle
+	}
+
+	// waiting for other tunnels in the namespace to stop
+	if policy != nil && policy.Reason == reasonTunnelLimitReached {
+		return kubexposev1.PhasePending
+	}
+
+	tunnel := meta.FindStatusCondition(conditions, kubexposev1.ConditionTunnelReady)
+	if tunnel != nil && (tunnel.Reason == reasonInvalidProvider || tunnel.Reason == reasonUnsupportedTunnel || tunnel.Reason == reasonInvalidAccess) {
+		return kubexposev1.PhaseFailed
+	}
+
+	source := meta.FindStatusCondition(conditions, kubexposev1.ConditionSourceFound)
+	if source != nil && source.Reason == reasonInvalidSource {
+		return kubexposev1.PhaseFailed
+	}
+
+	service := meta.FindStatusCondition(conditions, kubexposev1.ConditionServiceReady)
+	if service != nil && service.Reason == reasonServicePortNotFound {
+		return kubexposev1.PhaseFailed
+	}
+
+	// the tunnel is stopped if the source does not exist (anymore)
+	if !meta.IsStatusConditionTrue(conditions, kubexposev1.ConditionSourceFound) {
+		return kubexposev1.PhasePending
+	}
+
+	// the public url is only usable while the tunnel is running
+	if meta.IsStatusConditionTrue(conditions, kubexposev1.ConditionURLAvailable) && meta.IsStatusConditionTrue(conditions, kubexposev1.ConditionTunnelReady) {
+		return kubexposev1.PhaseReady
+	}
+
+	return kubexposev1.PhaseProvisioning
+}
+
+// podProblem describes why the tunnel Pod is not running - e.g. the container is in CrashLoopBackOff.
+// an empty string is returned if all the containers are ready
+func podProblem(pod *corev1.Pod) string {
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Ready {
+			continue
+		}
+		if cs.State.Waiting != nil && cs.State.Waiting.Reason != "" {
+			return fmt.Sprintf("container %s is waiting: %s %s", cs.Name, cs.State.Waiting.Reason, cs.State.Waiting.Message)
+		}
+		if cs.State.Terminated != nil {
+			return fmt.Sprintf("container %s terminated: %s (exit code %d)", cs.Name, cs.State.Terminated.Reason, cs.State.Terminated.ExitCode)
+		}
+		return fmt.Sprintf("container %s is not ready", cs.Name)
+	}
+
+	if pod.Status.Phase != corev1.PodRunning {
+		return fmt.Sprintf("pod is %s", pod.Status.Phase)
+	}
+	return ""
+}
+
+// podStarting tells whether the Pod is still being started, as opposed to being stuck e.g. in CrashLoopBackOff.
+// a running container which is not ready yet (e.g. cloudflared has not connected to the edge) is considered to be starting
+func podStarting(pod *corev1.Pod) bool {
+	switch pod.Status.Phase {
+	case corev1.PodPending:
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.State.Waiting != nil && cs.State.Waiting.Reason != "ContainerCreating" && cs.State.Waiting.Reason != "PodInitializing" {
+				return false
+			}
+		}
+		return true
+	case corev1.PodRunning:
+		for _, cs := range pod.Status.ContainerStatuses {
+			if !cs.Ready && cs.State.Running == nil {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
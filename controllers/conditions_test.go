@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"testing"
+
+	kubexposev1 "github.com/abhirockzz/kubexpose-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPodStarting(t *testing.T) {
+	running := corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}
+	waiting := func(reason string) corev1.ContainerState {
+		return corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason}}
+	}
+	terminated := corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Error", ExitCode: 1}}
+
+	tests := []struct {
+		name     string
+		phase    corev1.PodPhase
+		state    corev1.ContainerState
+		ready    bool
+		starting bool
+	}{
+		{name: "container creating", phase: corev1.PodPending, state: waiting("ContainerCreating"), starting: true},
+		{name: "image pull failing", phase: corev1.PodPending, state: waiting("ImagePullBackOff")},
+		{name: "readiness probe not passed yet", phase: corev1.PodRunning, state: running, starting: true},
+		{name: "ready", phase: corev1.PodRunning, state: running, ready: true, starting: true},
+		{name: "crashlooping", phase: corev1.PodRunning, state: waiting("CrashLoopBackOff")},
+		{name: "terminated", phase: corev1.PodRunning, state: terminated},
+		{name: "failed", phase: corev1.PodFailed, state: terminated},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			pod := &corev1.Pod{Status: corev1.PodStatus{
+				Phase:             tc.phase,
+				ContainerStatuses: []corev1.ContainerStatus{{Name: "cloudflared-web", State: tc.state, Ready: tc.ready}},
+			}}
+			if got := podStarting(pod); got != tc.starting {
+				t.Errorf("podStarting = %v, want %v", got, tc.starting)
+			}
+		})
+	}
+}
+
+func TestPhaseFor(t *testing.T) {
+	condition := func(conditionType string, status metaV1.ConditionStatus, reason string) metaV1.Condition {
+		return metaV1.Condition{Type: conditionType, Status: status, Reason: reason}
+	}
+	sourceFound := condition(kubexposev1.ConditionSourceFound, metaV1.ConditionTrue, reasonSourceFound)
+	tunnelHealthy := condition(kubexposev1.ConditionTunnelReady, metaV1.ConditionTrue, reasonTunnelHealthy)
+	urlDiscovered := condition(kubexposev1.ConditionURLAvailable, metaV1.ConditionTrue, reasonURLDiscovered)
+
+	tests := []struct {
+		name       string
+		conditions []metaV1.Condition
+		phase      kubexposev1.KubexposePhase
+	}{
+		{"new", nil, kubexposev1.PhasePending},
+		{"source not found", []metaV1.Condition{condition(kubexposev1.ConditionSourceFound, metaV1.ConditionFalse, reasonSourceNotFound)}, kubexposev1.PhasePending},
+		{"invalid source", []metaV1.Condition{condition(kubexposev1.ConditionSourceFound, metaV1.ConditionFalse, reasonInvalidSource)}, kubexposev1.PhaseFailed},
+		{"tunnel starting", []metaV1.Condition{sourceFound, condition(kubexposev1.ConditionTunnelReady, metaV1.ConditionFalse, reasonDeploymentCreated)}, kubexposev1.PhaseProvisioning},
+		{"ready", []metaV1.Condition{sourceFound, tunnelHealthy, urlDiscovered}, kubexposev1.PhaseReady},
+		{
+			"crashlooping",
+			[]metaV1.Condition{
+				sourceFound,
+				condition(kubexposev1.ConditionTunnelReady, metaV1.ConditionFalse, reasonTunnelPodFailing),
+				condition(kubexposev1.ConditionURLAvailable, metaV1.ConditionFalse, reasonTunnelPodFailing),
+			},
+			kubexposev1.PhaseProvisioning,
+		},
+		{"unhealthy with a url", []metaV1.Condition{sourceFound, condition(kubexposev1.ConditionTunnelReady, metaV1.ConditionFalse, reasonTunnelUnhealthy), urlDiscovered}, kubexposev1.PhaseProvisioning},
+		{
+			"source deleted with a url",
+			[]metaV1.Condition{condition(kubexposev1.ConditionSourceFound, metaV1.ConditionFalse, reasonSourceNotFound), tunnelHealthy, urlDiscovered},
+			kubexposev1.PhasePending,
+		},
+		{"invalid provider", []metaV1.Condition{sourceFound, condition(kubexposev1.ConditionTunnelReady, metaV1.ConditionFalse, reasonInvalidProvider)}, kubexposev1.PhaseFailed},
+		{"service port not found", []metaV1.Condition{sourceFound, condition(kubexposev1.ConditionServiceReady, metaV1.ConditionFalse, reasonServicePortNotFound)}, kubexposev1.PhaseFailed},
+		{"expired", []metaV1.Condition{condition(kubexposev1.ConditionExpired, metaV1.ConditionTrue, reasonExpired), sourceFound}, kubexposev1.PhaseExpired},
+		{"policy violation", []metaV1.Condition{condition(kubexposev1.ConditionPolicyCompliant, metaV1.ConditionFalse, reasonPolicyViolation), sourceFound, tunnelHealthy, urlDiscovered}, kubexposev1.PhaseFailed},
+		{"tunnel limit reached", []metaV1.Condition{condition(kubexposev1.ConditionPolicyCompliant, metaV1.ConditionFalse, reasonTunnelLimitReached), sourceFound}, kubexposev1.PhasePending},
+		{"window closed", []metaV1.Condition{condition(kubexposev1.ConditionWithinSchedule, metaV1.ConditionFalse, reasonWindowClosed), sourceFound}, kubexposev1.PhaseIdle},
+		{"invalid schedule", []metaV1.Condition{condition(kubexposev1.ConditionWithinSchedule, metaV1.ConditionFalse, reasonInvalidSchedule)}, kubexposev1.PhaseFailed},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			kexp := &kubexposev1.Kubexpose{Status: kubexposev1.KubexposeStatus{Conditions: tc.conditions}}
+			if got := phaseFor(kexp); got != tc.phase {
+				t.Errorf("phaseFor = %s, want %s", got, tc.phase)
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package controllers
+
+import (
+	stderror "errors"
+)
+
+// permanentError means that the Kubexpose resource can't be reconciled as specified (e.g. the source is invalid). retrying won't help
+type permanentError struct {
+	msg string
+}
+
+func (e permanentError) Error() string {
+	return e.msg
+}
+
+// isPermanent tells whether retrying won't help with the error
+func isPermanent(err error) bool {
+	var permanentErr permanentError
+	return stderror.As(err, &permanentErr)
+}
@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	kubexposev1 "github.com/abhirockzz/kubexpose-operator/api/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+// reasons for the Events recorded for Kubexpose resources
+const (
+	eventTunnelScaledDown   = "TunnelScaledDown"
+	eventTunnelClosed       = "TunnelClosed"
+	eventTeardownTimeout    = "TeardownTimeout"
+	eventExpiringSoon       = "ExpiringSoon"
+	eventExpired            = "Expired"
+	eventPolicyViolation    = "PolicyViolation"
+	eventSourceNotFound     = "SourceNotFound"
+	eventInvalidSource      = "InvalidSource"
+	eventServiceCreated     = "ServiceCreated"
+	eventTunnelCreated      = "TunnelDeploymentCreated"
+	eventTunnelFailed       = "TunnelFailed"
+	eventURLAssigned        = "URLAssigned"
+	eventURLChanged         = "URLChanged"
+	eventDiscoveryFailed    = "URLDiscoveryFailed"
+	eventNameNotHonoured    = "ReservedNameNotHonoured"
+	eventNotificationFailed = "NotificationFailed"
+	eventPublishFailed      = "PublishFailed"
+	eventSourceRolledOut    = "SourceRolledOut"
+)
+
+// recordTransition records an Event unless the condition is already set with the given reason.
+// this way, the Event is recorded once when the condition changes, rather than for each reconciliation
+func (r *KubexposeReconciler) recordTransition(kexp *kubexposev1.Kubexpose, conditionType, conditionReason, eventType, eventReason, message string) {
+	condition := meta.FindStatusCondition(kexp.Status.Conditions, conditionType)
+	if condition != nil && condition.Reason == conditionReason {
+		return
+	}
+	r.Recorder.Event(kexp, eventType, eventReason, message)
+}
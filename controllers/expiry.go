@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	kubexposev1 "github.com/abhirockzz/kubexpose-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// a warning Event is recorded this long before the Kubexpose resource expires
+const expiryWarningPeriod = 5 * time.Minute
+
+// tunnelStop explains why the tunnel is stopped (scaled down to zero) e.g. the Kubexpose resource has expired
+type tunnelStop struct {
+	reason  string
+	message string
+}
+
+// checkExpiry updates the expiry in the status and tells whether the tunnel has to be stopped since the Kubexpose resource has expired.
+// the returned duration is when the Kubexpose resource needs to be reconciled next - to record the warning Event or to stop the tunnel.
+// the Kubexpose resource is deleted if the expiry action is Delete, in which case deleted is true
+func (r *KubexposeReconciler) checkExpiry(ctx context.Context, req ctrl.Request, kexp *kubexposev1.Kubexpose, now time.Time) (stop *tunnelStop, next time.Duration, deleted bool, err error) {
+	logger := log.Log.WithValues("kubexpose", req.NamespacedName)
+
+	expiry := kexp.Expiry()
+	kexp.Status.ExpiresAt = expiry
+
+	if expiry == nil {
+		removeCondition(kexp, kubexposev1.ConditionExpired)
+		return nil, 0, false, nil
+	}
+
+	remaining := expiry.Time.Sub(now)
+
+	if remaining <= 0 {
+		message := fmt.Sprintf("expired at %s", expiry.UTC().Format(time.RFC3339))
+
+		if !meta.IsStatusConditionTrue(kexp.Status.Conditions, kubexposev1.ConditionExpired) {
+			logger.Info("kubexpose resource expired", "expiresAt", expiry.String(), "action", expiryActionFor(kexp))
+			r.Recorder.Eventf(kexp, corev1.EventTypeNormal, eventExpired, "%s. public url %s is no longer available", message, kexp.Status.PublicURL)
+		}
+		setCondition(kexp, kubexposev1.ConditionExpired, metaV1.ConditionTrue, reasonExpired, message)
+
+		if expiryActionFor(kexp) == kubexposev1.ExpiryActionDelete {
+			// the tunnel is torn down by the finalizer
+			err = r.Delete(ctx, kexp)
+			return nil, 0, true, client.IgnoreNotFound(err)
+		}
+
+		return &tunnelStop{reason: reasonExpired, message: message}, 0, false, nil
+	}
+
+	message := fmt.Sprintf("expires at %s", expiry.UTC().Format(time.RFC3339))
+
+	if remaining <= expiryWarningPeriod {
+		expiring := meta.FindStatusCondition(kexp.Status.Conditions, kubexposev1.ConditionExpired)
+		if expiring == nil || expiring.Reason != reasonExpiringSoon {
+			r.Recorder.Eventf(kexp, corev1.EventTypeWarning, eventExpiringSoon, "public url %s %s (in %s)", kexp.Status.PublicURL, message, remaining.Round(time.Second))
+		}
+		setCondition(kexp, kubexposev1.ConditionExpired, metaV1.ConditionFalse, reasonExpiringSoon, message)
+		return nil, remaining, false, nil
+	}
+
+	setCondition(kexp, kubexposev1.ConditionExpired, metaV1.ConditionFalse, reasonNotExpired, message)
+	return nil, remaining - expiryWarningPeriod, false, nil
+}
+
+func expiryActionFor(kexp *kubexposev1.Kubexpose) string {
+	if kexp.Spec.ExpiryAction == "" {
+		return kubexposev1.ExpiryActionTeardown
+	}
+	return kexp.Spec.ExpiryAction
+}
+
+// requeueBy makes sure that the result requeues the request within the given duration. zero means no deadline
+func requeueBy(result ctrl.Result, after time.Duration) ctrl.Result {
+	if after <= 0 {
+		return result
+	}
+	if result.RequeueAfter == 0 || after < result.RequeueAfter {
+		result.RequeueAfter = after
+	}
+	return result
+}
@@ -0,0 +1,209 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	kubexposev1 "github.com/abhirockzz/kubexpose-operator/api/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCheckExpiry(t *testing.T) {
+	now := time.Date(2021, time.June, 7, 10, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *metaV1.Time {
+		t := metaV1.NewTime(now.Add(d))
+		return &t
+	}
+	ttl := func(d time.Duration) *metaV1.Duration {
+		return &metaV1.Duration{Duration: d}
+	}
+	expiring := []metaV1.Condition{{Type: kubexposev1.ConditionExpired, Status: metaV1.ConditionFalse, Reason: reasonExpiringSoon}}
+	expired := []metaV1.Condition{{Type: kubexposev1.ConditionExpired, Status: metaV1.ConditionTrue, Reason: reasonExpired}}
+
+	tests := []struct {
+		name       string
+		spec       kubexposev1.KubexposeSpec
+		conditions []metaV1.Condition
+		expiresAt  *metaV1.Time
+		next       time.Duration
+		stop       bool
+		deleted    bool
+		reason     string
+		event      string
+	}{
+		{name: "no expiry"},
+		{
+			name:      "ttl",
+			spec:      kubexposev1.KubexposeSpec{TTL: ttl(time.Hour)},
+			expiresAt: at(50 * time.Minute),
+			next:      45 * time.Minute,
+			reason:    reasonNotExpired,
+		},
+		{
+			name:      "expiresAt",
+			spec:      kubexposev1.KubexposeSpec{ExpiresAt: at(20 * time.Minute)},
+			expiresAt: at(20 * time.Minute),
+			next:      15 * time.Minute,
+			reason:    reasonNotExpired,
+		},
+		{
+			name:      "earlier of ttl and expiresAt",
+			spec:      kubexposev1.KubexposeSpec{TTL: ttl(time.Hour), ExpiresAt: at(2 * time.Hour)},
+			expiresAt: at(50 * time.Minute),
+			next:      45 * time.Minute,
+			reason:    reasonNotExpired,
+		},
+		{
+			name:      "warning window",
+			spec:      kubexposev1.KubexposeSpec{ExpiresAt: at(3 * time.Minute)},
+			expiresAt: at(3 * time.Minute),
+			next:      3 * time.Minute,
+			reason:    reasonExpiringSoon,
+			event:     eventExpiringSoon,
+		},
+		{
+			name:       "warning already recorded",
+			spec:       kubexposev1.KubexposeSpec{ExpiresAt: at(2 * time.Minute)},
+			conditions: expiring,
+			expiresAt:  at(2 * time.Minute),
+			next:       2 * time.Minute,
+			reason:     reasonExpiringSoon,
+		},
+		{
+			name:      "start of the warning window",
+			spec:      kubexposev1.KubexposeSpec{ExpiresAt: at(expiryWarningPeriod)},
+			expiresAt: at(expiryWarningPeriod),
+			next:      expiryWarningPeriod,
+			reason:    reasonExpiringSoon,
+			event:     eventExpiringSoon,
+		},
+		{
+			name:      "expired teardown",
+			spec:      kubexposev1.KubexposeSpec{TTL: ttl(10 * time.Minute), ExpiryAction: kubexposev1.ExpiryActionTeardown},
+			expiresAt: at(0),
+			stop:      true,
+			reason:    reasonExpired,
+			event:     eventExpired,
+		},
+		{
+			name:      "expired with the default action",
+			spec:      kubexposev1.KubexposeSpec{ExpiresAt: at(-time.Minute)},
+			expiresAt: at(-time.Minute),
+			stop:      true,
+			reason:    reasonExpired,
+			event:     eventExpired,
+		},
+		{
+			name:       "expiry already recorded",
+			spec:       kubexposev1.KubexposeSpec{ExpiresAt: at(-time.Hour)},
+			conditions: expired,
+			expiresAt:  at(-time.Hour),
+			stop:       true,
+			reason:     reasonExpired,
+		},
+		{
+			name:      "expired delete",
+			spec:      kubexposev1.KubexposeSpec{ExpiresAt: at(-time.Minute), ExpiryAction: kubexposev1.ExpiryActionDelete},
+			expiresAt: at(-time.Minute),
+			deleted:   true,
+			reason:    reasonExpired,
+			event:     eventExpired,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			kexp := &kubexposev1.Kubexpose{
+				ObjectMeta: metaV1.ObjectMeta{Namespace: "default", Name: "app", CreationTimestamp: *at(-10 * time.Minute)},
+				Spec:       tc.spec,
+				Status:     kubexposev1.KubexposeStatus{Conditions: tc.conditions},
+			}
+
+			ctx := context.Background()
+			recorder := record.NewFakeRecorder(10)
+			r := &KubexposeReconciler{Client: fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(kexp.DeepCopy()).Build(), Recorder: recorder}
+			req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "app"}}
+
+			stop, next, deleted, err := r.checkExpiry(ctx, req, kexp, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if (stop != nil) != tc.stop {
+				t.Errorf("stop %v, want %v", stop, tc.stop)
+			}
+			if next != tc.next {
+				t.Errorf("next %s, want %s", next, tc.next)
+			}
+			if deleted != tc.deleted {
+				t.Errorf("deleted %v, want %v", deleted, tc.deleted)
+			}
+			err = r.Get(ctx, req.NamespacedName, &kubexposev1.Kubexpose{})
+			if gone := client.IgnoreNotFound(err) == nil && err != nil; gone != tc.deleted {
+				t.Errorf("kubexpose resource deleted %v, want %v", gone, tc.deleted)
+			}
+
+			if !equalTime(kexp.Status.ExpiresAt, tc.expiresAt) {
+				t.Errorf("status.expiresAt %v, want %v", kexp.Status.ExpiresAt, tc.expiresAt)
+			}
+
+			condition := meta.FindStatusCondition(kexp.Status.Conditions, kubexposev1.ConditionExpired)
+			if tc.reason == "" {
+				if condition != nil {
+					t.Errorf("unexpected condition %v", condition)
+				}
+			} else if condition == nil || condition.Reason != tc.reason {
+				t.Errorf("condition %v, want reason %s", condition, tc.reason)
+			}
+
+			select {
+			case event := <-recorder.Events:
+				if tc.event == "" || !strings.Contains(event, tc.event) {
+					t.Errorf("unexpected event %s", event)
+				}
+			default:
+				if tc.event != "" {
+					t.Errorf("expected a %s event", tc.event)
+				}
+			}
+		})
+	}
+}
+
+func equalTime(a, b *metaV1.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(b)
+}
+
+func TestRequeueBy(t *testing.T) {
+	tests := []struct {
+		name   string
+		result ctrl.Result
+		after  time.Duration
+		want   time.Duration
+	}{
+		{"no deadline", ctrl.Result{}, 0, 0},
+		{"no deadline keeps the requeue", ctrl.Result{RequeueAfter: 5 * time.Second}, 0, 5 * time.Second},
+		{"deadline", ctrl.Result{}, time.Minute, time.Minute},
+		{"earlier deadline", ctrl.Result{RequeueAfter: time.Hour}, time.Minute, time.Minute},
+		{"earlier requeue", ctrl.Result{RequeueAfter: 5 * time.Second}, time.Minute, 5 * time.Second},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := requeueBy(tc.result, tc.after); got.RequeueAfter != tc.want {
+				t.Errorf("requeueBy(%v, %s) = %s, want %s", tc.result, tc.after, got.RequeueAfter, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	kubexposev1 "github.com/abhirockzz/kubexpose-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// how long to wait for the tunnel session to close before the Kubexpose resource is released anyway
+	teardownTimeout = 2 * time.Minute
+	// how often to check whether the tunnel session has closed
+	teardownPollInterval = 2 * time.Second
+)
+
+// finalize tears down the tunnel and cleans up the objects created for the Kubexpose resource.
+// the finalizer is removed (and the Kubexpose resource released) only after the tunnel session is closed, so that the public url is not accessible anymore
+func (r *KubexposeReconciler) finalize(ctx context.Context, req ctrl.Request, kexp *kubexposev1.Kubexpose) (ctrl.Result, error) {
+	logger := log.Log.WithValues("kubexpose", req.NamespacedName)
+
+	if !controllerutil.ContainsFinalizer(kexp, cleanupFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	closed, err := r.teardownTunnel(ctx, req, kexp)
+	if err != nil {
+		logger.Error(err, "failed to teardown tunnel")
+		return ctrl.Result{}, err
+	}
+
+	if !closed {
+		if time.Since(kexp.DeletionTimestamp.Time) < teardownTimeout {
+			logger.Info("waiting for tunnel session to close")
+			return ctrl.Result{RequeueAfter: teardownPollInterval}, nil
+		}
+
+		logger.Info("tunnel session did not close in time", "timeout", teardownTimeout.String())
+		r.Recorder.Eventf(kexp, corev1.EventTypeWarning, eventTeardownTimeout, "tunnel session did not close within %s", teardownTimeout)
+	}
+
+	err = r.cleanup(ctx, req, kexp)
+	if err != nil {
+		logger.Error(err, "failed to cleanup")
+		return ctrl.Result{}, err
+	}
+
+	if closed {
+		r.Recorder.Eventf(kexp, corev1.EventTypeNormal, eventTunnelClosed, "tunnel session closed. public url %s is no longer accessible", kexp.Status.PublicURL)
+	}
+
+	r.notifyRevoked(ctx, req, kexp)
+
+	controllerutil.RemoveFinalizer(kexp, cleanupFinalizer)
+	err = r.Update(ctx, kexp)
+	if err != nil {
+		logger.Error(err, "failed to remove finalizer")
+		return ctrl.Result{}, err
+	}
+
+	logger.Info("teardown complete. finalizer removed")
+	return ctrl.Result{}, nil
+}
+
+// teardownTunnel scales the tunnel Deployment down to zero and tells whether the tunnel session has been closed.
+// the session is considered closed once none of the tunnel Pods are connected to the tunnel provider
+func (r *KubexposeReconciler) teardownTunnel(ctx context.Context, req ctrl.Request, kexp *kubexposev1.Kubexpose) (bool, error) {
+	logger := log.Log.WithValues("kubexpose", req.NamespacedName)
+
+	scaled, err := r.scaleDownTunnels(ctx, req, kexp)
+	if err != nil {
+		return false, err
+	}
+	if scaled {
+		r.setTerminating(ctx, req, kexp)
+	}
+
+	provider, err := providerFor(kexp)
+	if err != nil {
+		// nothing to check the session with
+		return true, nil
+	}
+
+	pods, err := r.listTunnelPods(ctx, kexp)
+	if err != nil {
+		return false, err
+	}
+
+	cfg := tunnelConfigFor(kexp)
+	containers := provider.PodSpec(kexp, cfg).Containers
+
+	for i := range pods {
+		active, err := provider.SessionActive(ctx, &podAgent{reconciler: r, pod: &pods[i]}, cfg)
+		if err != nil {
+			// the provider API is not reachable once the tunnel has stopped. otherwise, the session might still be active
+			stopped, stoppedErr := r.tunnelStopped(ctx, &pods[i], containers)
+			if stoppedErr != nil {
+				return false, stoppedErr
+			}
+			if !stopped {
+				logger.Info("tunnel api not reachable. checking again", "pod", pods[i].Name, "error", err.Error())
+				return false, nil
+			}
+			continue
+		}
+		if active {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// tunnelStopped tells whether the Pod is gone or none of its tunnel containers are running
+func (r *KubexposeReconciler) tunnelStopped(ctx context.Context, pod *corev1.Pod, containers []corev1.Container) (bool, error) {
+	var latest corev1.Pod
+	err := r.Get(ctx, client.ObjectKeyFromObject(pod), &latest)
+	if errors.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if latest.Status.Phase == corev1.PodSucceeded || latest.Status.Phase == corev1.PodFailed {
+		return true, nil
+	}
+
+	for _, container := range containers {
+		found := false
+		for _, status := range latest.Status.ContainerStatuses {
+			if status.Name != container.Name {
+				continue
+			}
+			found = true
+			if status.State.Running != nil {
+				return false, nil
+			}
+		}
+		// the status is not reported yet
+		if !found {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// scaleDownTunnels scales the tunnel Deployments (in any namespace) labelled with the Kubexpose resource down to zero.
+// it tells whether any of them had to be scaled down. the Deployments are not created if they don't exist
+func (r *KubexposeReconciler) scaleDownTunnels(ctx context.Context, req ctrl.Request, kexp *kubexposev1.Kubexpose) (bool, error) {
+	logger := log.Log.WithValues("kubexpose", req.NamespacedName)
+
+	var deployments appsv1.DeploymentList
+	err := r.List(ctx, &deployments, client.MatchingLabels(ownerLabels(kexp)))
+	if err != nil {
+		return false, err
+	}
+
+	scaled := false
+	for i := range deployments.Items {
+		dep := &deployments.Items[i]
+		if dep.Spec.Replicas != nil && *dep.Spec.Replicas == 0 {
+			continue
+		}
+
+		logger.Info("scaling down tunnel deployment", "namespace", dep.Namespace, "name", dep.Name)
+
+		zero := int32(0)
+		dep.Spec.Replicas = &zero
+		err = r.Update(ctx, dep)
+		if err != nil {
+			return false, err
+		}
+
+		r.Recorder.Eventf(kexp, corev1.EventTypeNormal, eventTunnelScaledDown, "scaled down tunnel deployment %s/%s", dep.Namespace, dep.Name)
+		scaled = true
+	}
+
+	return scaled, nil
+}
+
+// setTerminating updates the status to reflect that the tunnel is being torn down. failures are ignored since the resource is going away
+func (r *KubexposeReconciler) setTerminating(ctx context.Context, req ctrl.Request, kexp *kubexposev1.Kubexpose) {
+	setCondition(kexp, kubexposev1.ConditionTunnelReady, metaV1.ConditionFalse, reasonTearingDown, "tunnel is being torn down")
+	kexp.Status.Phase = kubexposev1.PhaseTerminating
+
+	_, _ = r.updateStatus(ctx, req, kexp)
+}
@@ -0,0 +1,270 @@
+package controllers
+
+import (
+	"context"
+	stderror "errors"
+	"strings"
+	"testing"
+	"time"
+
+	kubexposev1 "github.com/abhirockzz/kubexpose-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// stubProvider reports the tunnel session of each Pod (by name) as configured
+type stubProvider struct {
+	active map[string]bool
+	errs   map[string]error
+}
+
+func (stubProvider) Name() string {
+	return "stub"
+}
+
+func (stubProvider) Validate(kexp *kubexposev1.Kubexpose, cfg tunnelConfig) error {
+	return nil
+}
+
+func (stubProvider) ConfigData(kexp *kubexposev1.Kubexpose, cfg tunnelConfig) (map[string]string, error) {
+	return nil, nil
+}
+
+func (stubProvider) PodSpec(kexp *kubexposev1.Kubexpose, cfg tunnelConfig) corev1.PodSpec {
+	return corev1.PodSpec{Containers: []corev1.Container{{Name: "tunnel"}}}
+}
+
+func (stubProvider) DiscoverURLs(ctx context.Context, agent tunnelAgent, cfg tunnelConfig) (map[string]string, error) {
+	return nil, nil
+}
+
+func (stubProvider) HealthCheck(ctx context.Context, agent tunnelAgent, cfg tunnelConfig) error {
+	return nil
+}
+
+func (p stubProvider) SessionActive(ctx context.Context, agent tunnelAgent, cfg tunnelConfig) (bool, error) {
+	pod := agent.(*podAgent).pod.Name
+	return p.active[pod], p.errs[pod]
+}
+
+func testScheme(t *testing.T) *runtime.Scheme {
+	t.Helper()
+	s := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := kubexposev1.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func teardownKubexpose(deletedAt time.Time) *kubexposev1.Kubexpose {
+	deletionTimestamp := metaV1.NewTime(deletedAt)
+	return &kubexposev1.Kubexpose{
+		ObjectMeta: metaV1.ObjectMeta{Namespace: "default", Name: "app", Finalizers: []string{cleanupFinalizer}, DeletionTimestamp: &deletionTimestamp},
+		Spec:       kubexposev1.KubexposeSpec{SourceDeploymentName: "nginx", PortToExpose: 80, Provider: "stub"},
+		Status:     kubexposev1.KubexposeStatus{PublicURL: "https://4b5c1e1f3a2d.ngrok.io"},
+	}
+}
+
+func tunnelPod(kexp *kubexposev1.Kubexpose, name string, status corev1.PodStatus) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metaV1.ObjectMeta{Namespace: "default", Name: name, Labels: map[string]string{"exposing": "nginx", "kubexpose-cr": kexp.Name}},
+		Status:     status,
+	}
+}
+
+func tunnelDeployment(kexp *kubexposev1.Kubexpose, replicas int32) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metaV1.ObjectMeta{Namespace: "default", Name: deploymentNameFor(kexp), Labels: ownerLabels(kexp)},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+	}
+}
+
+func containerState(state corev1.ContainerState) corev1.PodStatus {
+	return corev1.PodStatus{Phase: corev1.PodRunning, ContainerStatuses: []corev1.ContainerStatus{{Name: "tunnel", State: state}}}
+}
+
+func TestTeardownTunnel(t *testing.T) {
+	running := containerState(corev1.ContainerState{Running: &corev1.ContainerStateRunning{}})
+	unreachable := stderror.New("connection refused")
+
+	tests := []struct {
+		name   string
+		status corev1.PodStatus
+		active bool
+		err    error
+		closed bool
+	}{
+		{"session active", running, true, nil, false},
+		{"session closed", running, false, nil, true},
+		{"api not reachable while the tunnel is running", running, false, unreachable, false},
+		{"api not reachable before the status is reported", corev1.PodStatus{Phase: corev1.PodPending}, false, unreachable, false},
+		{"tunnel container terminated", containerState(corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0}}), false, unreachable, true},
+		{"tunnel container restarting", containerState(corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}}), false, unreachable, true},
+		{"pod failed", corev1.PodStatus{Phase: corev1.PodFailed}, false, unreachable, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			registerProvider(stubProvider{active: map[string]bool{"tunnel-0": tc.active}, errs: map[string]error{"tunnel-0": tc.err}})
+
+			kexp := teardownKubexpose(time.Now())
+			ctx := context.Background()
+			r := &KubexposeReconciler{
+				Client:   fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(kexp, tunnelPod(kexp, "tunnel-0", tc.status), tunnelDeployment(kexp, 1)).Build(),
+				Recorder: record.NewFakeRecorder(10),
+			}
+			req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "app"}}
+
+			closed, err := r.teardownTunnel(ctx, req, kexp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if closed != tc.closed {
+				t.Errorf("closed %v, want %v", closed, tc.closed)
+			}
+
+			var dep appsv1.Deployment
+			if err := r.Get(ctx, types.NamespacedName{Namespace: "default", Name: deploymentNameFor(kexp)}, &dep); err != nil {
+				t.Fatal(err)
+			}
+			if *dep.Spec.Replicas != 0 {
+				t.Errorf("tunnel deployment not scaled down, replicas %d", *dep.Spec.Replicas)
+			}
+			if kexp.Status.Phase != kubexposev1.PhaseTerminating {
+				t.Errorf("phase %s, want %s", kexp.Status.Phase, kubexposev1.PhaseTerminating)
+			}
+		})
+	}
+}
+
+func TestTunnelStoppedPodGone(t *testing.T) {
+	kexp := teardownKubexpose(time.Now())
+	r := &KubexposeReconciler{Client: fake.NewClientBuilder().WithScheme(testScheme(t)).Build()}
+
+	stopped, err := r.tunnelStopped(context.Background(), tunnelPod(kexp, "tunnel-0", corev1.PodStatus{}), []corev1.Container{{Name: "tunnel"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stopped {
+		t.Error("tunnel of a deleted pod is not considered stopped")
+	}
+}
+
+func TestScaleDownTunnels(t *testing.T) {
+	kexp := teardownKubexpose(time.Now())
+	previous := tunnelDeployment(kexp, 2)
+	previous.Namespace = "apps"
+	other := &appsv1.Deployment{ObjectMeta: metaV1.ObjectMeta{Namespace: "default", Name: "nginx"}, Spec: appsv1.DeploymentSpec{Replicas: new(int32)}}
+	*other.Spec.Replicas = 3
+
+	ctx := context.Background()
+	recorder := record.NewFakeRecorder(10)
+	r := &KubexposeReconciler{
+		Client:   fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(tunnelDeployment(kexp, 1), previous, other).Build(),
+		Recorder: recorder,
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "app"}}
+
+	scaled, err := r.scaleDownTunnels(ctx, req, kexp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !scaled {
+		t.Error("expected the tunnel deployments to be scaled down")
+	}
+
+	want := map[types.NamespacedName]int32{
+		{Namespace: "default", Name: deploymentNameFor(kexp)}: 0,
+		{Namespace: "apps", Name: deploymentNameFor(kexp)}:    0,
+		{Namespace: "default", Name: "nginx"}:                 3,
+	}
+	for key, replicas := range want {
+		var dep appsv1.Deployment
+		if err := r.Get(ctx, key, &dep); err != nil {
+			t.Fatal(err)
+		}
+		if *dep.Spec.Replicas != replicas {
+			t.Errorf("%s has %d replicas, want %d", key, *dep.Spec.Replicas, replicas)
+		}
+	}
+	if len(recorder.Events) != 2 {
+		t.Errorf("expected an event per scaled down deployment, got %d", len(recorder.Events))
+	}
+
+	scaled, err = r.scaleDownTunnels(ctx, req, kexp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scaled {
+		t.Error("tunnel deployments scaled down again")
+	}
+}
+
+func TestFinalize(t *testing.T) {
+	tests := []struct {
+		name      string
+		deletedAt time.Time
+		active    bool
+		requeue   bool
+		event     string
+	}{
+		{"session closed", time.Now(), false, false, eventTunnelClosed},
+		{"waiting for the session to close", time.Now(), true, true, ""},
+		{"session did not close in time", time.Now().Add(-teardownTimeout - time.Second), true, false, eventTeardownTimeout},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			registerProvider(stubProvider{active: map[string]bool{"tunnel-0": tc.active}})
+
+			kexp := teardownKubexpose(tc.deletedAt)
+			running := containerState(corev1.ContainerState{Running: &corev1.ContainerStateRunning{}})
+			svc := &corev1.Service{ObjectMeta: metaV1.ObjectMeta{Namespace: "default", Name: serviceNameFor(kexp), Labels: ownerLabels(kexp)}}
+
+			ctx := context.Background()
+			recorder := record.NewFakeRecorder(10)
+			c := fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(kexp, tunnelPod(kexp, "tunnel-0", running), tunnelDeployment(kexp, 1), svc).Build()
+			r := &KubexposeReconciler{Client: c, MetadataReader: c, Recorder: recorder}
+			req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "app"}}
+
+			result, err := r.finalize(ctx, req, kexp)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if requeued := result.RequeueAfter == teardownPollInterval; requeued != tc.requeue {
+				t.Errorf("requeued %v, want %v", requeued, tc.requeue)
+			}
+			if released := !controllerutil.ContainsFinalizer(kexp, cleanupFinalizer); released == tc.requeue {
+				t.Errorf("finalizer removed %v, want %v", released, !tc.requeue)
+			}
+
+			err = r.Get(ctx, client.ObjectKeyFromObject(svc), &corev1.Service{})
+			if deleted := client.IgnoreNotFound(err) == nil && err != nil; deleted == tc.requeue {
+				t.Errorf("service deleted %v, want %v", deleted, !tc.requeue)
+			}
+
+			found := tc.event == ""
+			for len(recorder.Events) > 0 {
+				if event := <-recorder.Events; tc.event != "" && strings.Contains(event, tc.event) {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("expected a %s event", tc.event)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	kubexposev1 "github.com/abhirockzz/kubexpose-operator/api/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// number of entries kept in the url history. the oldest ones are dropped
+const maxURLHistory = 20
+
+// recordURLHistory retires the urls in the history which are no longer in use, and adds the ones which are new.
+// the history is ordered by assignment time, the latest one first
+func recordURLHistory(kexp *kubexposev1.Kubexpose, urls []kubexposev1.PortURL, podName string, now metaV1.Time) {
+	status := &kexp.Status
+	changed := false
+
+	inUse := func(entry kubexposev1.URLHistoryEntry) bool {
+		for _, u := range urls {
+			if u.Name == entry.Port && u.URL == entry.URL {
+				return true
+			}
+		}
+		return false
+	}
+
+	for i := range status.URLHistory {
+		entry := &status.URLHistory[i]
+		if entry.RetiredAt == nil && !inUse(*entry) {
+			retiredAt := now
+			entry.RetiredAt = &retiredAt
+			changed = true
+		}
+	}
+
+	var added []kubexposev1.URLHistoryEntry
+	for _, u := range urls {
+		known := false
+		for _, entry := range status.URLHistory {
+			if entry.RetiredAt == nil && entry.Port == u.Name && entry.URL == u.URL {
+				known = true
+				break
+			}
+		}
+		if !known {
+			added = append(added, kubexposev1.URLHistoryEntry{Port: u.Name, URL: u.URL, AssignedAt: now, PodName: podName})
+		}
+	}
+
+	if len(added) > 0 {
+		status.URLHistory = append(added, status.URLHistory...)
+		changed = true
+	}
+	if len(status.URLHistory) > maxURLHistory {
+		status.URLHistory = status.URLHistory[:maxURLHistory]
+	}
+
+	if changed {
+		lastChange := now
+		status.LastURLChange = &lastChange
+	}
+}
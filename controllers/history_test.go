@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	kubexposev1 "github.com/abhirockzz/kubexpose-operator/api/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRecordURLHistory(t *testing.T) {
+	kexp := &kubexposev1.Kubexpose{}
+	start := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	at := func(minutes int) metaV1.Time {
+		return metaV1.NewTime(start.Add(time.Duration(minutes) * time.Minute))
+	}
+
+	// assigned
+	recordURLHistory(kexp, []kubexposev1.PortURL{{Name: "default", URL: "https://one.ngrok.io"}}, "pod-1", at(0))
+	// same url - nothing changes
+	recordURLHistory(kexp, []kubexposev1.PortURL{{Name: "default", URL: "https://one.ngrok.io"}}, "pod-1", at(1))
+
+	history := kexp.Status.URLHistory
+	if len(history) != 1 || history[0].RetiredAt != nil || history[0].PodName != "pod-1" || !history[0].AssignedAt.Equal(&metaV1.Time{Time: start}) {
+		t.Fatalf("unexpected history %+v", history)
+	}
+	if !kexp.Status.LastURLChange.Equal(&metaV1.Time{Time: start}) {
+		t.Fatalf("unexpected last url change %v", kexp.Status.LastURLChange)
+	}
+
+	// the tunnel Pod is restarted
+	recordURLHistory(kexp, []kubexposev1.PortURL{{Name: "default", URL: "https://two.ngrok.io"}}, "pod-2", at(5))
+
+	history = kexp.Status.URLHistory
+	if len(history) != 2 || history[0].URL != "https://two.ngrok.io" || history[0].RetiredAt != nil || history[0].PodName != "pod-2" {
+		t.Fatalf("unexpected history %+v", history)
+	}
+	retired := at(5)
+	if history[1].URL != "https://one.ngrok.io" || history[1].RetiredAt == nil || !history[1].RetiredAt.Equal(&retired) {
+		t.Fatalf("url was not retired %+v", history[1])
+	}
+
+	// the tunnel is stopped
+	recordURLHistory(kexp, nil, "", at(10))
+
+	for _, entry := range kexp.Status.URLHistory {
+		if entry.RetiredAt == nil {
+			t.Fatalf("url was not retired %+v", entry)
+		}
+	}
+	if stopped := at(10); !kexp.Status.LastURLChange.Equal(&stopped) {
+		t.Fatalf("unexpected last url change %v", kexp.Status.LastURLChange)
+	}
+
+	// the history is bounded
+	for i := 0; i < 2*maxURLHistory; i++ {
+		recordURLHistory(kexp, []kubexposev1.PortURL{{Name: "default", URL: fmt.Sprintf("https://%d.ngrok.io", i)}}, "pod", at(20+i))
+	}
+	if len(kexp.Status.URLHistory) != maxURLHistory {
+		t.Fatalf("expected %d entries, got %d", maxURLHistory, len(kexp.Status.URLHistory))
+	}
+	if latest := fmt.Sprintf("https://%d.ngrok.io", 2*maxURLHistory-1); kexp.Status.URLHistory[0].URL != latest {
+		t.Fatalf("expected latest url %s first, got %s", latest, kexp.Status.URLHistory[0].URL)
+	}
+}
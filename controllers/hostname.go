@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"net/url"
+	"strings"
+
+	kubexposev1 "github.com/abhirockzz/kubexpose-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// checkReservedNames sets the ReservedName condition depending on whether the public urls use the requested hostname or subdomain.
+// the provider may fall back to a random one e.g. if the name is reserved by another account, in which case a Warning Event is recorded
+func (r *KubexposeReconciler) checkReservedNames(kexp *kubexposev1.Kubexpose, tunnels []tunnel, urls []kubexposev1.PortURL) {
+	requested := false
+	var mismatches []string
+
+	for i, t := range tunnels {
+		if t.hostname == "" && t.subdomain == "" {
+			continue
+		}
+		requested = true
+		if !nameHonoured(t, urls[i].URL) {
+			mismatches = append(mismatches, t.name+" ("+urls[i].URL+")")
+		}
+	}
+
+	if !requested {
+		removeCondition(kexp, kubexposev1.ConditionReservedName)
+		return
+	}
+
+	if len(mismatches) > 0 {
+		msg := "reserved name not used for port " + strings.Join(mismatches, ", ")
+		r.recordTransition(kexp, kubexposev1.ConditionReservedName, reasonNameNotHonoured, corev1.EventTypeWarning, eventNameNotHonoured, msg)
+		setCondition(kexp, kubexposev1.ConditionReservedName, metaV1.ConditionFalse, reasonNameNotHonoured, msg)
+		return
+	}
+	setCondition(kexp, kubexposev1.ConditionReservedName, metaV1.ConditionTrue, reasonNameHonoured, "")
+}
+
+// nameHonoured checks the host of the public url against the reserved hostname, or the first label against the subdomain
+func nameHonoured(t tunnel, publicURL string) bool {
+	u, err := url.Parse(publicURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+
+	if t.hostname != "" {
+		return host == strings.ToLower(t.hostname)
+	}
+	return strings.HasPrefix(host, strings.ToLower(t.subdomain)+".")
+}
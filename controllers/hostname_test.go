@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNameHonoured(t *testing.T) {
+	tests := []struct {
+		name   string
+		tunnel tunnel
+		url    string
+		want   bool
+	}{
+		{"hostname", tunnel{hostname: "app.example.com"}, "https://app.example.com", true},
+		{"hostname case", tunnel{hostname: "App.Example.com"}, "https://app.example.com", true},
+		{"hostname with port", tunnel{hostname: "app.example.com"}, "tls://app.example.com:443", true},
+		{"random hostname", tunnel{hostname: "app.example.com"}, "https://1a2b3c4d.ngrok.io", false},
+		{"subdomain", tunnel{subdomain: "myapp"}, "https://myapp.ngrok.io", true},
+		{"subdomain in region", tunnel{subdomain: "myapp"}, "https://myapp.eu.ngrok.io", true},
+		{"subdomain prefix only", tunnel{subdomain: "myapp"}, "https://myapp2.ngrok.io", false},
+		{"random subdomain", tunnel{subdomain: "myapp"}, "https://1a2b3c4d.ngrok.io", false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := nameHonoured(tc.tunnel, tc.url); got != tc.want {
+				t.Errorf("nameHonoured(%+v, %s) = %v, want %v", tc.tunnel, tc.url, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCloudflaredRemoteHostnames(t *testing.T) {
+	tests := []struct {
+		name   string
+		logs   string
+		want   []string
+		remote bool
+	}{
+		{"local configuration", "INF Starting tunnel tunnelID=1234\nINF Registered tunnel connection connIndex=0\n", nil, false},
+		{
+			"remote configuration",
+			`INF Updated to new configuration config="{\"ingress\":[{\"hostname\":\"app.example.com\", \"service\":\"http://nginx:80\"}, {\"service\":\"http_status:404\"}]}" version=1`,
+			[]string{"app.example.com"},
+			true,
+		},
+		{
+			"latest remote configuration wins",
+			`INF Updated to new configuration config="{\"ingress\":[{\"hostname\":\"old.example.com\", \"service\":\"http://nginx:80\"}]}" version=1` + "\n" +
+				`INF Updated to new configuration config="{\"ingress\":[{\"hostname\":\"web.example.com\", \"service\":\"http://nginx:80\"}, {\"hostname\":\"app.example.com\", \"service\":\"http://nginx:80\"}]}" version=2`,
+			[]string{"web.example.com", "app.example.com"},
+			true,
+		},
+		{"no public hostname", `INF Updated to new configuration config="{\"ingress\":[{\"service\":\"http_status:404\"}]}" version=1`, nil, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, remote := cloudflaredRemoteHostnames([]byte(tc.logs))
+			if !reflect.DeepEqual(got, tc.want) || remote != tc.remote {
+				t.Errorf("cloudflaredRemoteHostnames() = %v, %v, want %v, %v", got, remote, tc.want, tc.remote)
+			}
+		})
+	}
+}
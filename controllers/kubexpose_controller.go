@@ -18,19 +18,28 @@ package controllers
 
 import (
 	"context"
-	"fmt"
 	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 
-	"k8s.io/apimachinery/pkg/types"
-
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	kubexposev1 "github.com/abhirockzz/kubexpose-operator/api/v1"
 )
@@ -39,6 +48,19 @@ import (
 type KubexposeReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// used to reach the admin API (via the Pod proxy) and logs of tunnel Pods
+	Clientset kubernetes.Interface
+	Recorder  record.EventRecorder
+	// reads the metadata of the Secrets from the cache. the Client does not cache Secrets, only their metadata is watched
+	MetadataReader client.Reader
+	// name of the Secret (in the target namespace) with the tunnel provider authtoken. used if the Kubexpose resource does not specify one
+	DefaultAuthSecret string
+	// number of Kubexpose resources which are reconciled at a time. notifications are delivered during reconciliation,
+	// hence a slow notify target should not hold up the others
+	MaxConcurrentReconciles int
+
+	// traffic through the tunnels, reported by tunnelCollector
+	traffic trafficCache
 }
 
 const (
@@ -49,13 +71,21 @@ const (
 //+kubebuilder:rbac:groups=kubexpose.kubexpose.io,resources=kubexposes,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=kubexpose.kubexpose.io,resources=kubexposes/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=kubexpose.kubexpose.io,resources=kubexposes/finalizers,verbs=update
+//+kubebuilder:rbac:groups=kubexpose.kubexpose.io,resources=kubexposepolicies,verbs=get;list;watch
 
 // +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=apps,resources=statefulsets;daemonsets;replicasets,verbs=get;list;watch
+// the source is rolled out when the published url changes
+// +kubebuilder:rbac:groups=apps,resources=statefulsets;daemonsets,verbs=patch
 // +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;create;update;patch;delete
 
-// kubexpose also needs to exec into the pod
-// +kubebuilder:rbac:groups=core,resources=pods/exec,verbs=create
+// kubexpose also needs to query the tunnel admin api via the pod proxy and read the tunnel container logs
+// +kubebuilder:rbac:groups=core,resources=pods/proxy,verbs=get
+// +kubebuilder:rbac:groups=core,resources=pods/log,verbs=get
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -72,7 +102,7 @@ func (r *KubexposeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 	if err != nil {
 		if errors.IsNotFound(err) {
 			// Request object not found, could have been deleted after reconcile request.
-			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
+			// The tunnel has been torn down and objects in other namespaces cleaned up by the finalizer.
 			// Return and don't requeue
 			logger.Info("kubexpose resource not found. ignoring since object must have been deleted")
 			return ctrl.Result{}, nil
@@ -82,41 +112,148 @@ func (r *KubexposeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		return ctrl.Result{}, err
 	}
 
-	// check for Service and create one if it does not exist
-	serviceName := fmt.Sprintf(serviceNameFormat, kubexposeResource.Spec.SourceDeploymentName, kubexposeResource.Name)
-	namespace := kubexposeResource.Spec.TargetNamespace
+	if !kubexposeResource.DeletionTimestamp.IsZero() {
+		return r.finalize(ctx, req, &kubexposeResource)
+	}
 
-	var kubexposeService corev1.Service
-	err = r.Get(ctx, types.NamespacedName{Namespace: req.Namespace, Name: serviceName}, &kubexposeService)
+	// the tunnel is torn down before the resource is deleted. objects in other namespaces are not garbage collected - they are cleaned up as well
+	if !controllerutil.ContainsFinalizer(&kubexposeResource, cleanupFinalizer) {
+		controllerutil.AddFinalizer(&kubexposeResource, cleanupFinalizer)
+		err = r.Update(ctx, &kubexposeResource)
+		if err != nil {
+			logger.Error(err, "failed to add finalizer")
+			return ctrl.Result{}, err
+		}
+	}
 
+	// keep a copy of the status - it's only updated if something changed during reconciliation
+	currentStatus := kubexposeResource.Status.DeepCopy()
+
+	stop, next, deleted, err := r.checkExpiry(ctx, req, &kubexposeResource, time.Now())
 	if err != nil {
-		if errors.IsNotFound(err) {
-			return r.createService(ctx, req, &kubexposeResource)
-		} else {
-			logger.Error(err, "failed to get Service")
-			return ctrl.Result{}, err
+		logger.Error(err, "failed to delete expired kubexpose resource")
+		return ctrl.Result{}, err
+	}
+	if deleted {
+		// the finalizer takes over from here
+		return ctrl.Result{}, nil
+	}
+
+	policyStop, err := r.checkPolicies(ctx, req, &kubexposeResource)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if stop == nil {
+		stop = policyStop
+	}
+
+	scheduleStop, nextWindow := r.checkSchedule(req, &kubexposeResource)
+	if stop == nil {
+		stop = scheduleStop
+	}
+
+	result, err := r.reconcileResource(ctx, req, &kubexposeResource, stop)
+	// make sure the expiry and scheduled windows are not missed
+	result = requeueBy(requeueBy(result, next), nextWindow)
+
+	// the public urls are published before the notify targets are told about them, so that the ConfigMap or Secret is up to date
+	if publishErr := r.publish(ctx, req, &kubexposeResource); publishErr != nil && err == nil {
+		err = publishErr
+	}
+
+	// the notify targets are told about changes to the urls as per the (in-memory) status. failed notifications are retried
+	result = requeueBy(result, r.notify(ctx, req, &kubexposeResource))
+
+	if isPermanent(err) {
+		// can't do much here. do not requeue
+		err = nil
+	}
+
+	// the printer columns need the source even if it's specified using the deprecated sourceDeployment
+	kubexposeResource.Status.Source = nil
+	if source := kubexposeResource.Spec.SourceRef(); source.Name != "" {
+		kubexposeResource.Status.Source = &source
+	}
+	kubexposeResource.Status.ObservedGeneration = kubexposeResource.Generation
+	kubexposeResource.Status.Phase = phaseFor(&kubexposeResource)
+
+	if !equality.Semantic.DeepEqual(currentStatus, &kubexposeResource.Status) {
+		if _, statusErr := r.updateStatus(ctx, req, &kubexposeResource); statusErr != nil {
+			return ctrl.Result{}, statusErr
 		}
 	}
 
-	// check for Deployment and create one if it does not exist
-	deploymentName := fmt.Sprintf(deploymentNameFormat, kubexposeResource.Spec.SourceDeploymentName, kubexposeResource.Name)
+	return result, err
+}
 
-	var ngrokDeployment appsv1.Deployment
-	err = r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: deploymentName}, &ngrokDeployment)
+// reconcileResource creates the Service and tunnel Deployment for the Kubexpose resource and looks up the public url.
+// if the tunnel has to be stopped, the tunnel Deployment is scaled down to zero and the public url is cleared - regardless of whether
+// the source, Secrets and provider are valid. the status of the Kubexpose resource is updated in-memory and persisted by Reconcile
+func (r *KubexposeReconciler) reconcileResource(ctx context.Context, req ctrl.Request, kubexposeResource *kubexposev1.Kubexpose, stop *tunnelStop) (ctrl.Result, error) {
+	logger := log.Log.WithValues("kubexpose", req.NamespacedName)
+
+	if stop != nil {
+		return r.stopTunnel(ctx, req, kubexposeResource, stop)
+	}
 
+	provider, err := providerFor(kubexposeResource)
 	if err != nil {
-		if errors.IsNotFound(err) {
-			return r.createDeployment(ctx, req, &kubexposeResource)
-		} else {
-			logger.Error(err, "failed to get deployment")
+		logger.Error(err, "invalid tunnel provider")
+		setCondition(kubexposeResource, kubexposev1.ConditionTunnelReady, metaV1.ConditionFalse, reasonInvalidProvider, err.Error())
+		// can't do much here. do not requeue
+		return ctrl.Result{}, nil
+	}
+
+	exposed, err := r.getSource(ctx, req, kubexposeResource)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if exposed == nil {
+		// the tunnel must not keep serving a source which was deleted (or is invalid). the objects created for a previous source or
+		// target namespace are not needed either. the Kubexpose resource is reconciled again once the source is created - do not requeue
+		err = r.deleteStale(ctx, req, kubexposeResource)
+		if err != nil {
+			logger.Error(err, "failed to delete stale objects")
 			return ctrl.Result{}, err
 		}
+
+		sourceFound := meta.FindStatusCondition(kubexposeResource.Status.Conditions, kubexposev1.ConditionSourceFound)
+		return r.stopTunnel(ctx, req, kubexposeResource, &tunnelStop{reason: sourceFound.Reason, message: sourceFound.Message})
+	}
+
+	// create the Service or update it to match the source and Kubexpose spec
+	serviceName := serviceNameFor(kubexposeResource)
+
+	_, err = r.reconcileService(ctx, req, kubexposeResource, exposed)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// create the tunnel Deployment or update it to match the Kubexpose spec
+	deploymentName := deploymentNameFor(kubexposeResource)
+
+	op, err := r.reconcileDeployment(ctx, req, kubexposeResource)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// the objects created for a previous source or target namespace are not needed anymore
+	err = r.deleteStale(ctx, req, kubexposeResource)
+	if err != nil {
+		logger.Error(err, "failed to delete stale objects")
+		return ctrl.Result{}, err
+	}
+
+	if op != controllerutil.OperationResultNone {
+		// the tunnel Pod takes a while to start - requeue
+		return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
 	}
 
 	statusURL := kubexposeResource.Status.PublicURL
 	logger.Info("url as per status", "kubexpose resource", kubexposeResource.Name, "url", statusURL)
 
-	latestNgrokURL, err := r.getURL(ctx, req, &kubexposeResource)
+	latestURLs, podName, err := r.getURLs(ctx, req, kubexposeResource)
 	if err != nil {
 		// there will be intermittent errors when trying to search for url.
 		// logging it as info to avoid console pollution
@@ -125,23 +262,113 @@ func (r *KubexposeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
 	}
 
+	// url is the one for the first port
+	latestURL := latestURLs[0].URL
+
 	// if they are not same, update the status with the new URL in deployment
-	if statusURL != latestNgrokURL {
-		kubexposeResource.Status.PublicURL = latestNgrokURL
-		return r.updateStatus(ctx, req, &kubexposeResource)
+	if statusURL != latestURL {
+		logger.Info("public url changed", "provider", provider.Name(), "old", statusURL, "new", latestURL)
+		kubexposeResource.Status.PublicURL = latestURL
 	}
+	// a tunnel which is restarted (e.g. rescheduled) typically gets a new url
+	for _, latest := range latestURLs {
+		current := ""
+		for _, u := range kubexposeResource.Status.URLs {
+			if u.Name == latest.Name {
+				current = u.URL
+			}
+		}
+
+		if current == "" {
+			r.Recorder.Eventf(kubexposeResource, corev1.EventTypeNormal, eventURLAssigned, "public url for port %s is %s", latest.Name, latest.URL)
+		} else if current != latest.URL {
+			urlChanges.WithLabelValues(provider.Name()).Inc()
+			r.Recorder.Eventf(kubexposeResource, corev1.EventTypeNormal, eventURLChanged, "public url for port %s changed from %s to %s", latest.Name, current, latest.URL)
+		}
+	}
+	kubexposeResource.Status.URLs = latestURLs
+	recordURLHistory(kubexposeResource, latestURLs, podName, metaV1.Now())
 
 	logger.Info("resource successfully reconciled", "service", serviceName, "deployment", deploymentName, "public url", kubexposeResource.Status.PublicURL)
+
+	// the traffic through the tunnels is fetched along with the urls. it's reported as metrics, hence it's kept up to date
+	if _, ok := provider.(trafficReporter); ok {
+		return ctrl.Result{RequeueAfter: trafficRefreshInterval}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// stopTunnel scales down the tunnel Deployment and clears the public url e.g. since the Kubexpose resource expired.
+// the tunnel Deployment is left as is otherwise, and created once the tunnel is to be run again
+func (r *KubexposeReconciler) stopTunnel(ctx context.Context, req ctrl.Request, kubexposeResource *kubexposev1.Kubexpose, stop *tunnelStop) (ctrl.Result, error) {
+	logger := log.Log.WithValues("kubexpose", req.NamespacedName)
+
+	_, err := r.scaleDownTunnels(ctx, req, kubexposeResource)
+	if err != nil {
+		logger.Error(err, "failed to scale down tunnel deployment")
+		return ctrl.Result{}, err
+	}
+
+	if kubexposeResource.Status.PublicURL != "" {
+		logger.Info("tunnel stopped. clearing public url", "reason", stop.reason, "url", kubexposeResource.Status.PublicURL)
+	}
+	kubexposeResource.Status.PublicURL = ""
+	kubexposeResource.Status.URLs = nil
+	recordURLHistory(kubexposeResource, nil, "", metaV1.Now())
+	setCondition(kubexposeResource, kubexposev1.ConditionTunnelReady, metaV1.ConditionFalse, stop.reason, stop.message)
+	setCondition(kubexposeResource, kubexposev1.ConditionURLAvailable, metaV1.ConditionFalse, stop.reason, stop.message)
 	return ctrl.Result{}, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *KubexposeReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	err := mgr.GetFieldIndexer().IndexField(context.Background(), &kubexposev1.Kubexpose{}, secretIndexField, r.indexSecrets)
+	if err != nil {
+		return err
+	}
+	err = mgr.GetFieldIndexer().IndexField(context.Background(), &kubexposev1.Kubexpose{}, exposedNamespaceIndexField, indexExposedNamespace)
+	if err != nil {
+		return err
+	}
+	err = mgr.GetFieldIndexer().IndexField(context.Background(), &kubexposev1.Kubexpose{}, sourceIndexField, indexSource)
+	if err != nil {
+		return err
+	}
+
+	// the number of active tunnels and their traffic (as of the latest reconciliation) is reported when the metrics are scraped
+	err = metrics.Registry.Register(&tunnelCollector{reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	// the Service and Deployment might be in a different namespace, hence the owner labels are used instead of Owns().
+	// each kind is watched once - the objects created by kubexpose and the sources are mapped by the same handler
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&kubexposev1.Kubexpose{}).
-		// will reconcile the service if it's modified/deleted externally
-		Owns(&corev1.Service{}).
-		// will reconcile the deployment if it's modified/deleted externally
-		Owns(&appsv1.Deployment{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		// will reconcile the service if it's modified/deleted externally, or if it's the source and it changes
+		Watches(&source.Kind{Type: &corev1.Service{}}, handler.EnqueueRequestsFromMapFunc(mapAll(mapToKubexpose, r.mapSourceToKubexpose(kubexposev1.SourceKindService)))).
+		// will reconcile the deployment if it's modified/deleted externally. the Kubexpose resources are also reconciled when their
+		// source is created, deleted or its spec (e.g. the selector) changes - the status of the workloads is not relevant
+		Watches(&source.Kind{Type: &appsv1.Deployment{}}, handler.EnqueueRequestsFromMapFunc(mapAll(mapToKubexpose, r.mapSourceToKubexpose(kubexposev1.SourceKindDeployment))),
+			builder.WithPredicates(predicate.Or(predicate.NewPredicateFuncs(ownedByKubexpose), predicate.GenerationChangedPredicate{}))).
+		// will reconcile the tunnel configuration if it's modified/deleted externally
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, handler.EnqueueRequestsFromMapFunc(mapToKubexpose)).
+		// will restart the tunnel if the authtoken or the Secrets used to restrict access change, and reconcile the Secret with the
+		// published url if it's modified/deleted externally. only the metadata of the Secrets is cached - they are read from the API server
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(mapAll(r.mapSecretToKubexpose, mapToKubexpose)), builder.OnlyMetadata).
+		Watches(&source.Kind{Type: &appsv1.StatefulSet{}}, handler.EnqueueRequestsFromMapFunc(r.mapSourceToKubexpose(kubexposev1.SourceKindStatefulSet)),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&source.Kind{Type: &appsv1.DaemonSet{}}, handler.EnqueueRequestsFromMapFunc(r.mapSourceToKubexpose(kubexposev1.SourceKindDaemonSet)),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&source.Kind{Type: &appsv1.ReplicaSet{}}, handler.EnqueueRequestsFromMapFunc(r.mapSourceToKubexpose(kubexposev1.SourceKindReplicaSet)),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		// only the labels of the source Pods are used
+		Watches(&source.Kind{Type: &corev1.Pod{}}, handler.EnqueueRequestsFromMapFunc(r.mapSourceToKubexpose(kubexposev1.SourceKindPod)),
+			builder.WithPredicates(predicate.LabelChangedPredicate{})).
+		// will re-evaluate the Kubexpose resources when a policy changes
+		Watches(&source.Kind{Type: &kubexposev1.KubexposePolicy{}}, handler.EnqueueRequestsFromMapFunc(r.mapPolicyToKubexpose)).
+		// will start or stop tunnels to stay within the number of tunnels per namespace allowed by the policies
+		Watches(&source.Kind{Type: &kubexposev1.Kubexpose{}}, handler.EnqueueRequestsFromMapFunc(r.mapToTunnelNamespace)).
 		Complete(r)
 }
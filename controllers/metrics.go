@@ -0,0 +1,182 @@
+package controllers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	kubexposev1 "github.com/abhirockzz/kubexpose-operator/api/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// the traffic through the tunnels is fetched during reconciliation - the Kubexpose resources with a public url are
+	// reconciled at this interval to keep the figures up to date
+	trafficRefreshInterval = time.Minute
+
+	// the traffic of a tunnel has to be fetched within this time
+	trafficTimeout = 5 * time.Second
+)
+
+var (
+	urlDiscoveryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kubexpose_url_discovery_duration_seconds",
+		Help:    "Time taken to fetch the public urls from the tunnel provider",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"provider"})
+
+	urlDiscoveryFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kubexpose_url_discovery_failures_total",
+		Help: "Number of times the public urls could not be discovered, by reason",
+	}, []string{"reason"})
+
+	urlChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kubexpose_url_changes_total",
+		Help: "Number of times the public url of a tunnel changed",
+	}, []string{"provider"})
+
+	notificationDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kubexpose_notifications_total",
+		Help: "Number of notifications posted to notify targets, by result",
+	}, []string{"result"})
+)
+
+// results of notifications
+const (
+	notificationResultDelivered = "delivered"
+	notificationResultFailed    = "failed"
+)
+
+var (
+	tunnelsActiveDesc = prometheus.NewDesc("kubexpose_tunnels_active",
+		"Number of Kubexpose resources with a public url", []string{"provider"}, nil)
+
+	tunnelRequestsDesc = prometheus.NewDesc("kubexpose_tunnel_requests_total",
+		"Number of http requests through the tunnel, as reported by the tunnel provider", []string{"namespace", "name", "port"}, nil)
+
+	tunnelConnectionsDesc = prometheus.NewDesc("kubexpose_tunnel_connections_total",
+		"Number of client connections through the tunnel, as reported by the tunnel provider", []string{"namespace", "name", "port"}, nil)
+)
+
+func init() {
+	metrics.Registry.MustRegister(urlDiscoveryDuration, urlDiscoveryFailures, urlChanges, notificationDeliveries)
+}
+
+// trafficCache keeps the traffic through the tunnels of each Kubexpose resource, as fetched during the latest reconciliation
+type trafficCache struct {
+	mu      sync.Mutex
+	traffic map[types.NamespacedName]map[string]tunnelTraffic
+}
+
+func (c *trafficCache) set(key types.NamespacedName, traffic map[string]tunnelTraffic) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.traffic == nil {
+		c.traffic = map[types.NamespacedName]map[string]tunnelTraffic{}
+	}
+	c.traffic[key] = traffic
+}
+
+// get returns the traffic for the Kubexpose resource. nil is returned if it has not been fetched yet
+func (c *trafficCache) get(key types.NamespacedName) map[string]tunnelTraffic {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.traffic[key]
+}
+
+// retain forgets the traffic of the Kubexpose resources which are not in keep e.g. since they have been deleted or their tunnel stopped
+func (c *trafficCache) retain(keep map[types.NamespacedName]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key := range c.traffic {
+		if !keep[key] {
+			delete(c.traffic, key)
+		}
+	}
+}
+
+// refreshTraffic fetches the traffic through the tunnels from the tunnel Pod behind the agent, for the providers which keep track of it
+func (r *KubexposeReconciler) refreshTraffic(ctx context.Context, kexp *kubexposev1.Kubexpose, provider TunnelProvider, agent tunnelAgent, cfg tunnelConfig) {
+	reporter, ok := provider.(trafficReporter)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, trafficTimeout)
+	defer cancel()
+
+	traffic, err := reporter.Traffic(ctx, agent, cfg)
+	if err != nil {
+		// the previous figures are reported until the next attempt
+		log.Log.Info("failed to fetch tunnel traffic", "kubexpose", kexp.Namespace+"/"+kexp.Name, "error", err.Error())
+		return
+	}
+
+	r.traffic.set(types.NamespacedName{Namespace: kexp.Namespace, Name: kexp.Name}, traffic)
+}
+
+// tunnelCollector reports the number of active tunnels and the traffic through each one of them. the traffic is not fetched
+// from the tunnel Pods when the metrics are scraped - the figures cached during reconciliation are reported instead
+type tunnelCollector struct {
+	reconciler *KubexposeReconciler
+}
+
+func (c *tunnelCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- tunnelsActiveDesc
+	ch <- tunnelRequestsDesc
+	ch <- tunnelConnectionsDesc
+}
+
+func (c *tunnelCollector) Collect(ch chan<- prometheus.Metric) {
+	// served from the informer cache
+	var kexps kubexposev1.KubexposeList
+	err := c.reconciler.List(context.Background(), &kexps)
+	if err != nil {
+		log.Log.Error(err, "failed to list kubexpose resources for metrics")
+		return
+	}
+
+	active := map[string]int{}
+	for name := range providers {
+		active[name] = 0
+	}
+	ready := map[types.NamespacedName]bool{}
+
+	for i := range kexps.Items {
+		kexp := &kexps.Items[i]
+		if kexp.Status.Phase != kubexposev1.PhaseReady {
+			continue
+		}
+
+		provider, err := providerFor(kexp)
+		if err != nil {
+			continue
+		}
+		active[provider.Name()]++
+
+		key := types.NamespacedName{Namespace: kexp.Namespace, Name: kexp.Name}
+		ready[key] = true
+
+		traffic := c.reconciler.traffic.get(key)
+		for _, t := range tunnelConfigFor(kexp).tunnels {
+			tt, ok := traffic[t.name]
+			if !ok {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(tunnelRequestsDesc, prometheus.CounterValue, float64(tt.requests), kexp.Namespace, kexp.Name, t.name)
+			ch <- prometheus.MustNewConstMetric(tunnelConnectionsDesc, prometheus.CounterValue, float64(tt.connections), kexp.Namespace, kexp.Name, t.name)
+		}
+	}
+
+	c.reconciler.traffic.retain(ready)
+
+	for name, count := range active {
+		ch <- prometheus.MustNewConstMetric(tunnelsActiveDesc, prometheus.GaugeValue, float64(count), name)
+	}
+}
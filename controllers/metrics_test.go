@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	kubexposev1 "github.com/abhirockzz/kubexpose-operator/api/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestTunnelCollector(t *testing.T) {
+	kexp := func(name string, phase kubexposev1.KubexposePhase) client.Object {
+		return &kubexposev1.Kubexpose{
+			ObjectMeta: metaV1.ObjectMeta{Namespace: "default", Name: name},
+			Spec:       kubexposev1.KubexposeSpec{SourceDeploymentName: "nginx", PortToExpose: 80},
+			Status:     kubexposev1.KubexposeStatus{Phase: phase},
+		}
+	}
+
+	r := &KubexposeReconciler{Client: fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(kexp("ready", kubexposev1.PhaseReady), kexp("pending", kubexposev1.PhasePending)).Build()}
+
+	ctx := context.Background()
+	// e.g. the tunnel has been reconciled before it was stopped or the resource was deleted
+	for _, name := range []string{"ready", "pending", "deleted"} {
+		k := kexp(name, "").(*kubexposev1.Kubexpose)
+		agent := &stubAgent{responses: map[string][]byte{"4040/api/tunnels": readPayload(t, "ngrok", "single_https.json")}}
+		r.refreshTraffic(ctx, k, ngrokProvider{}, agent, tunnelConfigFor(k))
+	}
+
+	// only the tunnel with a public url is reported
+	collector := &tunnelCollector{reconciler: r}
+	err := testutil.CollectAndCompare(collector, strings.NewReader(`
+# HELP kubexpose_tunnel_connections_total Number of client connections through the tunnel, as reported by the tunnel provider
+# TYPE kubexpose_tunnel_connections_total counter
+kubexpose_tunnel_connections_total{name="ready",namespace="default",port="default"} 3
+# HELP kubexpose_tunnel_requests_total Number of http requests through the tunnel, as reported by the tunnel provider
+# TYPE kubexpose_tunnel_requests_total counter
+kubexpose_tunnel_requests_total{name="ready",namespace="default",port="default"} 5
+`), "kubexpose_tunnel_connections_total", "kubexpose_tunnel_requests_total")
+	if err != nil {
+		t.Error(err)
+	}
+
+	// every provider is reported, including the ones without tunnels
+	active := `
+# HELP kubexpose_tunnels_active Number of Kubexpose resources with a public url
+# TYPE kubexpose_tunnels_active gauge
+`
+	for name := range providers {
+		count := 0
+		if name == "ngrok" {
+			count = 1
+		}
+		active += fmt.Sprintf("kubexpose_tunnels_active{provider=%q} %d\n", name, count)
+	}
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(active), "kubexpose_tunnels_active"); err != nil {
+		t.Error(err)
+	}
+
+	if r.traffic.get(types.NamespacedName{Namespace: "default", Name: "deleted"}) != nil {
+		t.Error("traffic of a deleted kubexpose resource is still cached")
+	}
+}
@@ -0,0 +1,298 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	kubexposev1 "github.com/abhirockzz/kubexpose-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	ngrokProviderName = "ngrok"
+	ngrokImage        = "wernight/ngrok"
+	ngrokAdminPort    = 4040
+	ngrokConfigDir    = "/etc/ngrok"
+	ngrokConfigFile   = "ngrok.yml"
+	ngrokAuthTokenEnv = "NGROK_AUTHTOKEN"
+)
+
+func init() {
+	registerProvider(ngrokProvider{})
+}
+
+// ngrokProvider exposes the Service using ngrok (https://ngrok.com/)
+type ngrokProvider struct{}
+
+func (ngrokProvider) Name() string {
+	return ngrokProviderName
+}
+
+// Validate accepts all the protocols since ngrok supports http, tcp and tls tunnels. anonymous tunnels are http only though.
+// tcp and tls tunnels, reserved hostnames, subdomains and regions are tied to an ngrok account, hence an authtoken is required
+func (ngrokProvider) Validate(kexp *kubexposev1.Kubexpose, cfg tunnelConfig) error {
+	if cfg.authToken != nil {
+		return nil
+	}
+	if cfg.region != "" {
+		return permanentError{msg: "ngrok requires an authtoken for region"}
+	}
+	for _, t := range cfg.tunnels {
+		if t.protocol != kubexposev1.ProtocolHTTP {
+			return permanentError{msg: fmt.Sprintf("ngrok requires an authtoken for protocol %s (port %s)", t.protocol, t.name)}
+		}
+		if t.hostname != "" || t.subdomain != "" {
+			return permanentError{msg: fmt.Sprintf("ngrok requires an authtoken for hostname or subdomain (port %s)", t.name)}
+		}
+	}
+	return nil
+}
+
+// ConfigData returns the ngrok configuration file with one tunnel per port.
+// the ngrok web interface (and API) is bound to 0.0.0.0:4040 which makes it reachable via the Pod proxy
+func (ngrokProvider) ConfigData(kexp *kubexposev1.Kubexpose, cfg tunnelConfig) (map[string]string, error) {
+	config := ngrokConfig{
+		WebAddr: fmt.Sprintf("0.0.0.0:%d", ngrokAdminPort),
+		Log:     "stdout",
+		Region:  cfg.region,
+		Tunnels: map[string]ngrokTunnelConfig{},
+	}
+
+	for _, t := range cfg.tunnels {
+		tc := ngrokTunnelConfig{Proto: t.protocol, Addr: t.address, Hostname: t.hostname, Subdomain: t.subdomain}
+		if t.protocol == kubexposev1.ProtocolHTTP {
+			// we only need https url
+			bindTLS := true
+			tc.BindTLS = &bindTLS
+		}
+		config.Tunnels[t.name] = tc
+	}
+
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{ngrokConfigFile: string(data)}, nil
+}
+
+// PodSpec runs ngrok with the tunnels in the configuration file. It's equivalent to - ngrok start --all --config ngrok.yml.
+// the authtoken (if any) is passed using an environment variable sourced from the Secret
+func (ngrokProvider) PodSpec(kexp *kubexposev1.Kubexpose, cfg tunnelConfig) corev1.PodSpec {
+	container := corev1.Container{
+		Name:    "ngrok",
+		Image:   ngrokImage,
+		Command: []string{"ngrok"},
+		Args:    []string{"start", "--all", "--config", ngrokConfigDir + "/" + ngrokConfigFile},
+		Ports:   []corev1.ContainerPort{{ContainerPort: ngrokAdminPort}},
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: "config", MountPath: ngrokConfigDir, ReadOnly: true},
+		},
+	}
+
+	if cfg.authToken != nil {
+		container.Env = []corev1.EnvVar{
+			{Name: ngrokAuthTokenEnv, ValueFrom: &corev1.EnvVarSource{SecretKeyRef: cfg.authToken}},
+		}
+		container.Args = append(container.Args, "--authtoken", "$("+ngrokAuthTokenEnv+")")
+	}
+
+	return corev1.PodSpec{
+		Containers: []corev1.Container{container},
+		Volumes: []corev1.Volume{
+			{
+				Name: "config",
+				VolumeSource: corev1.VolumeSource{
+					ConfigMap: &corev1.ConfigMapVolumeSource{
+						LocalObjectReference: corev1.LocalObjectReference{Name: cfg.configMapName},
+					},
+				},
+			},
+		},
+	}
+}
+
+// DiscoverURLs uses the ngrok API to find the URL for each tunnel. see ngrokURLs
+func (p ngrokProvider) DiscoverURLs(ctx context.Context, agent tunnelAgent, cfg tunnelConfig) (map[string]string, error) {
+	ngrokInfo, err := p.tunnels(ctx, agent)
+	if err != nil {
+		return nil, err
+	}
+	return ngrokURLs(ngrokInfo, cfg.tunnels)
+}
+
+// ngrokURLs picks the public url of each tunnel from the ones reported by the ngrok API.
+// ngrok names the tunnels as per the configuration file, but the ones which don't have the expected name are matched using
+// the upstream address instead. only the tunnels with the expected protocol are considered - https is preferred for http tunnels
+func ngrokURLs(ngrokInfo NgrokInfo, tunnels []tunnel) (map[string]string, error) {
+	urls := map[string]string{}
+
+	for _, t := range tunnels {
+		var byName, byAddress []ngrokTunnel
+		for _, nt := range ngrokInfo.Tunnels {
+			if !ngrokProtocolMatches(nt.Proto, t.protocol) {
+				continue
+			}
+			// with bind_tls: both, ngrok names the http tunnel <name> (http)
+			if strings.TrimSuffix(nt.Name, " (http)") == t.name {
+				byName = append(byName, nt)
+			} else if ngrokAddress(nt.Config.Addr) == ngrokAddress(t.address) {
+				byAddress = append(byAddress, nt)
+			}
+		}
+
+		candidates := byName
+		if len(candidates) == 0 {
+			candidates = byAddress
+		}
+		candidates = preferHTTPS(candidates)
+
+		// ngrok container is not ready. give it a while
+		if len(candidates) == 0 {
+			return nil, urlNotReadyError{msg: fmt.Sprintf("ngrok tunnel %s is not ready", t.name)}
+		}
+
+		publicURL := candidates[0].PublicURL
+		for _, c := range candidates[1:] {
+			if c.PublicURL != publicURL {
+				return nil, urlAmbiguousError{msg: fmt.Sprintf("ngrok tunnels %s and %s both match tunnel %s", candidates[0].Name, c.Name, t.name)}
+			}
+		}
+
+		if publicURL == "" {
+			return nil, urlNotReadyError{msg: fmt.Sprintf("ngrok tunnel %s does not have a public url yet", t.name)}
+		}
+		parsed, err := url.Parse(publicURL)
+		if err != nil || parsed.Host == "" || !ngrokProtocolMatches(parsed.Scheme, t.protocol) {
+			return nil, urlMalformedError{msg: fmt.Sprintf("ngrok tunnel %s has an invalid public url %q", t.name, publicURL)}
+		}
+
+		urls[t.name] = publicURL
+	}
+
+	return urls, nil
+}
+
+// ngrokProtocolMatches tells whether the protocol (or url scheme) reported by ngrok is the one for the tunnel
+func ngrokProtocolMatches(ngrokProto, protocol string) bool {
+	if protocol == kubexposev1.ProtocolHTTP {
+		return ngrokProto == "https" || ngrokProto == "http"
+	}
+	return ngrokProto == protocol
+}
+
+// preferHTTPS drops the http tunnels if there are https ones
+func preferHTTPS(tunnels []ngrokTunnel) []ngrokTunnel {
+	var https []ngrokTunnel
+	for _, nt := range tunnels {
+		if nt.Proto == "https" {
+			https = append(https, nt)
+		}
+	}
+	if len(https) == 0 {
+		return tunnels
+	}
+	return https
+}
+
+// ngrokAddress normalises the upstream address of a tunnel - ngrok reports svc:80 as http://svc:80
+func ngrokAddress(addr string) string {
+	if i := strings.Index(addr, "://"); i >= 0 {
+		addr = addr[i+3:]
+	}
+	return addr
+}
+
+// HealthCheck confirms that the ngrok API is responding. the response is checked by DiscoverURLs, which tells an empty response
+// (ngrok is starting) from a malformed one
+func (p ngrokProvider) HealthCheck(ctx context.Context, agent tunnelAgent, cfg tunnelConfig) error {
+	_, err := agent.Get(ctx, ngrokAdminPort, "/api/tunnels")
+	return err
+}
+
+// SessionActive checks whether ngrok still has any tunnels open
+func (p ngrokProvider) SessionActive(ctx context.Context, agent tunnelAgent, cfg tunnelConfig) (bool, error) {
+	ngrokInfo, err := p.tunnels(ctx, agent)
+	if err != nil {
+		return false, err
+	}
+	return len(ngrokInfo.Tunnels) > 0, nil
+}
+
+// Traffic uses the ngrok API to find the number of connections and http requests for each tunnel
+func (p ngrokProvider) Traffic(ctx context.Context, agent tunnelAgent, cfg tunnelConfig) (map[string]tunnelTraffic, error) {
+	ngrokInfo, err := p.tunnels(ctx, agent)
+	if err != nil {
+		return nil, err
+	}
+
+	traffic := map[string]tunnelTraffic{}
+	for _, t := range ngrokInfo.Tunnels {
+		traffic[t.Name] = tunnelTraffic{requests: t.Metrics.HTTP.Count, connections: t.Metrics.Conns.Count}
+	}
+	return traffic, nil
+}
+
+func (ngrokProvider) tunnels(ctx context.Context, agent tunnelAgent) (NgrokInfo, error) {
+	resp, err := agent.Get(ctx, ngrokAdminPort, "/api/tunnels")
+	if err != nil {
+		return NgrokInfo{}, err
+	}
+	return parseNgrokInfo(resp)
+}
+
+func parseNgrokInfo(resp []byte) (NgrokInfo, error) {
+	var ngrokInfo NgrokInfo
+
+	if len(resp) == 0 {
+		return ngrokInfo, urlNotReadyError{msg: "no response from ngrok api"}
+	}
+
+	err := json.Unmarshal(resp, &ngrokInfo)
+	if err != nil {
+		return ngrokInfo, urlMalformedError{msg: "invalid response from ngrok api: " + err.Error()}
+	}
+	return ngrokInfo, nil
+}
+
+// ngrok configuration file - https://ngrok.com/docs#config
+type ngrokConfig struct {
+	WebAddr string                       `json:"web_addr"`
+	Log     string                       `json:"log,omitempty"`
+	Region  string                       `json:"region,omitempty"`
+	Tunnels map[string]ngrokTunnelConfig `json:"tunnels"`
+}
+
+type ngrokTunnelConfig struct {
+	Proto     string `json:"proto"`
+	Addr      string `json:"addr"`
+	BindTLS   *bool  `json:"bind_tls,omitempty"`
+	Hostname  string `json:"hostname,omitempty"`
+	Subdomain string `json:"subdomain,omitempty"`
+}
+
+// json response for ngrok info - curl http://localhost:4040/api/tunnels
+type NgrokInfo struct {
+	Tunnels []ngrokTunnel `json:"tunnels"`
+}
+
+type ngrokTunnel struct {
+	Name      string `json:"name"`
+	PublicURL string `json:"public_url"`
+	Proto     string `json:"proto"`
+	Config    struct {
+		Addr string `json:"addr"`
+	} `json:"config"`
+	Metrics struct {
+		Conns ngrokMetric `json:"conns"`
+		HTTP  ngrokMetric `json:"http"`
+	} `json:"metrics"`
+}
+
+// traffic through an ngrok tunnel
+type ngrokMetric struct {
+	Count int64 `json:"count"`
+}
@@ -0,0 +1,254 @@
+package controllers
+
+import (
+	"context"
+	stderror "errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	kubexposev1 "github.com/abhirockzz/kubexpose-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/yaml"
+)
+
+func TestNgrokURLs(t *testing.T) {
+	nginx := tunnel{name: "default", protocol: "http", address: "nginx-svc-kubexpose-test:80"}
+
+	tests := []struct {
+		payload string
+		tunnels []tunnel
+		want    map[string]string
+		wantErr interface{}
+	}{
+		{
+			payload: "single_https.json",
+			tunnels: []tunnel{nginx},
+			want:    map[string]string{"default": "https://4b5c1e1f3a2d.ngrok.io"},
+		},
+		{
+			payload: "single_http.json",
+			tunnels: []tunnel{nginx},
+			want:    map[string]string{"default": "http://4b5c1e1f3a2d.ngrok.io"},
+		},
+		{
+			payload: "bind_tls_both.json",
+			tunnels: []tunnel{nginx},
+			want:    map[string]string{"default": "https://7d1a0b8e6c3f.ngrok.io"},
+		},
+		{
+			payload: "multi_port.json",
+			tunnels: []tunnel{
+				{name: "web", protocol: "http", address: "localhost:8080"},
+				{name: "redis", protocol: "tcp", address: "redis-svc-kubexpose-test:6379"},
+			},
+			want: map[string]string{"web": "https://9e2f4c7a1b0d.ngrok.io", "redis": "tcp://2.tcp.ngrok.io:14391"},
+		},
+		{
+			// the tunnel does not have the configured name, but the upstream address matches
+			payload: "command_line.json",
+			tunnels: []tunnel{nginx},
+			want:    map[string]string{"default": "https://a1b2c3d4e5f6.ngrok.io"},
+		},
+		{
+			payload: "ambiguous.json",
+			tunnels: []tunnel{nginx},
+			wantErr: &urlAmbiguousError{},
+		},
+		{
+			payload: "no_tunnels.json",
+			tunnels: []tunnel{nginx},
+			wantErr: &urlNotReadyError{},
+		},
+		{
+			payload: "multi_port.json",
+			tunnels: []tunnel{nginx},
+			wantErr: &urlNotReadyError{},
+		},
+		{
+			payload: "wrong_protocol.json",
+			tunnels: []tunnel{nginx},
+			wantErr: &urlNotReadyError{},
+		},
+		{
+			payload: "invalid_url.json",
+			tunnels: []tunnel{nginx},
+			wantErr: &urlMalformedError{},
+		},
+		{
+			payload: "truncated.json",
+			tunnels: []tunnel{nginx},
+			wantErr: &urlMalformedError{},
+		},
+		{
+			payload: "html.txt",
+			tunnels: []tunnel{nginx},
+			wantErr: &urlMalformedError{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.payload, func(t *testing.T) {
+			resp, err := ioutil.ReadFile(filepath.Join("testdata", "ngrok", tt.payload))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var urls map[string]string
+			ngrokInfo, err := parseNgrokInfo(resp)
+			if err == nil {
+				urls, err = ngrokURLs(ngrokInfo, tt.tunnels)
+			}
+
+			if tt.wantErr != nil {
+				if !stderror.As(err, tt.wantErr) {
+					t.Fatalf("expected %T, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if !reflect.DeepEqual(urls, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, urls)
+			}
+		})
+	}
+}
+
+func TestParseNgrokInfoEmpty(t *testing.T) {
+	_, err := parseNgrokInfo(nil)
+	if !stderror.As(err, &urlNotReadyError{}) {
+		t.Fatalf("expected urlNotReadyError, got %v", err)
+	}
+}
+
+// countingAgent counts the requests to the admin API
+type countingAgent struct {
+	stubAgent
+	requests int
+}
+
+func (a *countingAgent) Get(ctx context.Context, port int, path string) ([]byte, error) {
+	a.requests++
+	return a.stubAgent.Get(ctx, port, path)
+}
+
+func TestNgrokMalformedResponse(t *testing.T) {
+	ctx := context.Background()
+	kexp := &kubexposev1.Kubexpose{ObjectMeta: metaV1.ObjectMeta{Namespace: "default", Name: "app"}}
+	cfg := tunnelConfig{tunnels: []tunnel{{name: "http", protocol: kubexposev1.ProtocolHTTP, address: "nginx-svc-app:80"}}}
+	r := &KubexposeReconciler{Recorder: record.NewFakeRecorder(10)}
+
+	counting := &countingAgent{stubAgent: stubAgent{responses: map[string][]byte{"4040/api/tunnels": readPayload(t, "ngrok", "truncated.json")}}}
+	agent := &cachingAgent{tunnelAgent: counting}
+	provider := ngrokProvider{}
+
+	// the api is reachable, hence the tunnel is healthy - it's the url which can't be found
+	if err := provider.HealthCheck(ctx, agent, cfg); err != nil {
+		t.Fatalf("unexpected health check error %v", err)
+	}
+	_, err := provider.DiscoverURLs(ctx, agent, cfg)
+	if err == nil {
+		t.Fatal("expected an error for a malformed response")
+	}
+	r.discoveryFailed(kexp, err)
+
+	condition := meta.FindStatusCondition(kexp.Status.Conditions, kubexposev1.ConditionURLAvailable)
+	if condition == nil || condition.Reason != reasonURLMalformed {
+		t.Errorf("URLAvailable condition %v, want reason %s", condition, reasonURLMalformed)
+	}
+
+	_, _ = provider.Traffic(ctx, agent, cfg)
+	if counting.requests != 1 {
+		t.Errorf("ngrok api queried %d times, want once", counting.requests)
+	}
+}
+
+func TestNgrokValidate(t *testing.T) {
+	authToken := &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "ngrok"}, Key: "authtoken"}
+
+	tests := []struct {
+		name      string
+		cfg       tunnelConfig
+		permanent bool
+	}{
+		{"anonymous http", tunnelConfig{tunnels: []tunnel{{name: "web", protocol: kubexposev1.ProtocolHTTP}}}, false},
+		{"anonymous tcp", tunnelConfig{tunnels: []tunnel{{name: "db", protocol: kubexposev1.ProtocolTCP}}}, true},
+		{"anonymous tls", tunnelConfig{tunnels: []tunnel{{name: "web", protocol: kubexposev1.ProtocolHTTP}, {name: "grpc", protocol: kubexposev1.ProtocolTLS}}}, true},
+		{"anonymous subdomain", tunnelConfig{tunnels: []tunnel{{name: "web", protocol: kubexposev1.ProtocolHTTP, subdomain: "app"}}}, true},
+		{"tcp with authtoken", tunnelConfig{tunnels: []tunnel{{name: "db", protocol: kubexposev1.ProtocolTCP}}, authToken: authToken}, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ngrokProvider{}.Validate(&kubexposev1.Kubexpose{}, tc.cfg)
+			if tc.permanent != isPermanent(err) || (!tc.permanent && err != nil) {
+				t.Errorf("unexpected error %v", err)
+			}
+		})
+	}
+}
+
+func TestNgrokConfigData(t *testing.T) {
+	web := tunnel{name: "web", protocol: kubexposev1.ProtocolHTTP, address: "nginx-svc-kubexpose-test:80"}
+	redis := tunnel{name: "redis", protocol: kubexposev1.ProtocolTCP, address: "redis-svc-kubexpose-test:6379"}
+	grpc := tunnel{name: "grpc", protocol: kubexposev1.ProtocolTLS, address: "grpc-svc-kubexpose-test:8443", hostname: "grpc.example.com"}
+
+	tests := []struct {
+		config string
+		cfg    tunnelConfig
+	}{
+		{"single_port.yml", tunnelConfig{tunnels: []tunnel{web}}},
+		{"multi_port.yml", tunnelConfig{tunnels: []tunnel{web, redis, grpc}}},
+		{"region_subdomain.yml", tunnelConfig{tunnels: []tunnel{{name: "web", protocol: kubexposev1.ProtocolHTTP, address: "localhost:8080", subdomain: "myapp"}}, region: "eu"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.config, func(t *testing.T) {
+			data, err := ngrokProvider{}.ConfigData(&kubexposev1.Kubexpose{}, tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := readPayload(t, filepath.Join("ngrok", "config"), tt.config)
+			if got := data[ngrokConfigFile]; got != string(want) {
+				t.Errorf("expected\n%s\ngot\n%s", want, got)
+			}
+		})
+	}
+}
+
+func TestNgrokPodSpec(t *testing.T) {
+	authToken := &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "ngrok"}, Key: "authtoken"}
+	tunnels := []tunnel{
+		{name: "web", protocol: kubexposev1.ProtocolHTTP, address: "nginx-svc-kubexpose-test:80"},
+		{name: "redis", protocol: kubexposev1.ProtocolTCP, address: "redis-svc-kubexpose-test:6379"},
+	}
+
+	tests := []struct {
+		podSpec string
+		cfg     tunnelConfig
+	}{
+		{"anonymous.yml", tunnelConfig{tunnels: tunnels[:1], configMapName: "nginx-expose-test-config"}},
+		{"authtoken.yml", tunnelConfig{tunnels: tunnels, configMapName: "nginx-expose-test-config", authToken: authToken}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.podSpec, func(t *testing.T) {
+			got, err := yaml.Marshal(ngrokProvider{}.PodSpec(&kubexposev1.Kubexpose{}, tt.cfg))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := readPayload(t, filepath.Join("ngrok", "podspec"), tt.podSpec)
+			if string(got) != string(want) {
+				t.Errorf("expected\n%s\ngot\n%s", want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,362 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	stderror "errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	kubexposev1 "github.com/abhirockzz/kubexpose-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// how long to wait for a notify target to respond. notifications are delivered during reconciliation, hence it's kept short
+	notifyTimeout = 3 * time.Second
+	// a notification is dropped after these many failed attempts
+	maxNotifyAttempts = 5
+	// delay before the first retry. it's doubled for each failed attempt
+	notifyRetryDelay    = 5 * time.Second
+	maxNotifyRetryDelay = 5 * time.Minute
+)
+
+// the notify targets are specified by users of the Kubexpose resources, hence the operator must not be used to reach
+// the addresses which are only reachable from within the cluster (e.g. the API server, cloud metadata or other Services).
+// the address is checked when connecting (rather than when validating the url) so that DNS names and redirects are covered as well.
+// proxies are not used since the address they connect to can't be checked
+var notifyClient = &http.Client{
+	Timeout: notifyTimeout,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: notifyTimeout, Control: checkNotifyAddress}).DialContext,
+		TLSHandshakeTimeout: notifyTimeout,
+		MaxIdleConns:        10,
+		IdleConnTimeout:     90 * time.Second,
+	},
+}
+
+// private, shared (carrier-grade NAT) and unique local ranges. they are commonly used for the Pod and Service networks
+var internalNetworks = mustParseCIDRs("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7")
+
+// checkNotifyAddress rejects loopback, link-local (e.g. cloud metadata), unspecified, multicast and internal addresses
+func checkNotifyAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return permanentError{msg: "invalid address " + host}
+	}
+
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast() {
+		return permanentError{msg: fmt.Sprintf("address %s is not allowed for notifications", ip)}
+	}
+	for _, ipNet := range internalNetworks {
+		if ipNet.Contains(ip) {
+			return permanentError{msg: fmt.Sprintf("internal address %s is not allowed for notifications", ip)}
+		}
+	}
+	return nil
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	var ipNets []*net.IPNet
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		ipNets = append(ipNets, ipNet)
+	}
+	return ipNets
+}
+
+// notification is posted to the notify targets when the public url of a port changes.
+// it's the request body unless the target has a template, in which case it's the data for the template
+type notification struct {
+	Event       kubexposev1.NotifyEvent `json:"event"`
+	Name        string                  `json:"name"`
+	Namespace   string                  `json:"namespace"`
+	Port        string                  `json:"port"`
+	URL         string                  `json:"url,omitempty"`
+	PreviousURL string                  `json:"previousURL,omitempty"`
+}
+
+// notify posts the pending notifications to each target in spec.notify and updates their delivery state in the status.
+// the urls each target has been notified of are kept in the status - the notifications are the difference between them and the current urls.
+// hence, changes in quick succession (or while a notification is being retried) are coalesced. the duration after which
+// failed notifications are to be retried is returned (zero if there are none)
+func (r *KubexposeReconciler) notify(ctx context.Context, req ctrl.Request, kexp *kubexposev1.Kubexpose) time.Duration {
+	var statuses []kubexposev1.NotificationStatus
+	var retryAfter time.Duration
+
+	for i := range kexp.Spec.Notify {
+		target := &kexp.Spec.Notify[i]
+
+		status := kubexposev1.NotificationStatus{Name: target.Name}
+		for _, s := range kexp.Status.Notifications {
+			if s.Name == target.Name {
+				status = *s.DeepCopy()
+			}
+		}
+
+		wait := r.notifyTarget(ctx, req, kexp, target, &status, time.Now())
+		if wait > 0 && (retryAfter == 0 || wait < retryAfter) {
+			retryAfter = wait
+		}
+		statuses = append(statuses, status)
+	}
+
+	// targets which have been removed from the spec are dropped
+	kexp.Status.Notifications = statuses
+	return retryAfter
+}
+
+// notifyTarget delivers the pending notifications to a target, one at a time. it stops at the first one which has to be retried
+func (r *KubexposeReconciler) notifyTarget(ctx context.Context, req ctrl.Request, kexp *kubexposev1.Kubexpose, target *kubexposev1.NotifyTarget, status *kubexposev1.NotificationStatus, now time.Time) time.Duration {
+	logger := log.Log.WithValues("kubexpose", req.NamespacedName)
+
+	for _, n := range pendingNotifications(kexp, kexp.Status.URLs, status.URLs) {
+		if !target.Notifies(n.Event) {
+			status.URLs = applyNotification(status.URLs, n)
+			continue
+		}
+
+		if status.State == kubexposev1.NotificationRetrying && status.LastAttemptTime != nil {
+			if wait := notifyBackoff(status.Attempts) - now.Sub(status.LastAttemptTime.Time); wait > 0 {
+				return wait
+			}
+		} else {
+			status.Attempts = 0
+		}
+
+		status.LastAttemptTime = &metaV1.Time{Time: now}
+		err := r.deliver(ctx, kexp, target, n)
+		if err == nil {
+			logger.Info("notification delivered", "target", target.Name, "event", n.Event, "port", n.Port)
+			notificationDeliveries.WithLabelValues(notificationResultDelivered).Inc()
+			status.State = kubexposev1.NotificationDelivered
+			status.Attempts = 0
+			status.Message = ""
+			status.LastDeliveryTime = &metaV1.Time{Time: now}
+			status.URLs = applyNotification(status.URLs, n)
+			continue
+		}
+
+		status.Attempts++
+		status.Message = err.Error()
+
+		// e.g. the template is invalid or the target rejected the request
+		if isPermanent(err) || status.Attempts >= maxNotifyAttempts {
+			logger.Info("notification dropped", "target", target.Name, "event", n.Event, "port", n.Port, "attempts", status.Attempts, "error", err.Error())
+			notificationDeliveries.WithLabelValues(notificationResultFailed).Inc()
+			r.Recorder.Eventf(kexp, corev1.EventTypeWarning, eventNotificationFailed, "failed to notify %s of %s for port %s after %d attempt(s): %v", target.Name, n.Event, n.Port, status.Attempts, err)
+			status.State = kubexposev1.NotificationFailed
+			status.URLs = applyNotification(status.URLs, n)
+			continue
+		}
+
+		logger.Info("notification failed. will retry", "target", target.Name, "event", n.Event, "port", n.Port, "attempts", status.Attempts, "error", err.Error())
+		status.State = kubexposev1.NotificationRetrying
+		return notifyBackoff(status.Attempts)
+	}
+
+	// the notification being retried is obsolete e.g. the url changed back to the one the target was notified of
+	if status.State == kubexposev1.NotificationRetrying {
+		status.State = ""
+		status.Attempts = 0
+		status.Message = ""
+	}
+	return 0
+}
+
+// notifyRevoked tells the targets that the public urls have been revoked when the Kubexpose resource is deleted.
+// it's best effort - each target gets a single attempt since the resource (and its status) is about to go away
+func (r *KubexposeReconciler) notifyRevoked(ctx context.Context, req ctrl.Request, kexp *kubexposev1.Kubexpose) {
+	logger := log.Log.WithValues("kubexpose", req.NamespacedName)
+
+	for i := range kexp.Spec.Notify {
+		target := &kexp.Spec.Notify[i]
+
+		var notified []kubexposev1.PortURL
+		for _, s := range kexp.Status.Notifications {
+			if s.Name == target.Name {
+				notified = s.URLs
+			}
+		}
+
+		for _, n := range pendingNotifications(kexp, nil, notified) {
+			if !target.Notifies(n.Event) {
+				continue
+			}
+			err := r.deliver(ctx, kexp, target, n)
+			if err != nil {
+				logger.Info("failed to notify target of revoked url", "target", target.Name, "port", n.Port, "error", err.Error())
+				notificationDeliveries.WithLabelValues(notificationResultFailed).Inc()
+				r.Recorder.Eventf(kexp, corev1.EventTypeWarning, eventNotificationFailed, "failed to notify %s of %s for port %s: %v", target.Name, n.Event, n.Port, err)
+				continue
+			}
+			notificationDeliveries.WithLabelValues(notificationResultDelivered).Inc()
+		}
+	}
+}
+
+// pendingNotifications compares the urls a target has been notified of with the current ones. the ports are in the same order as the current urls,
+// followed by the ones which have been revoked
+func pendingNotifications(kexp *kubexposev1.Kubexpose, current, notified []kubexposev1.PortURL) []notification {
+	var pending []notification
+
+	previous := map[string]string{}
+	for _, u := range notified {
+		previous[u.Name] = u.URL
+	}
+
+	for _, u := range current {
+		n := notification{Name: kexp.Name, Namespace: kexp.Namespace, Port: u.Name, URL: u.URL, PreviousURL: previous[u.Name]}
+		switch n.PreviousURL {
+		case u.URL:
+			continue
+		case "":
+			n.Event = kubexposev1.NotifyURLAssigned
+		default:
+			n.Event = kubexposev1.NotifyURLChanged
+		}
+		pending = append(pending, n)
+	}
+
+	for _, u := range notified {
+		if urlFor(current, u.Name) == "" {
+			pending = append(pending, notification{Event: kubexposev1.NotifyURLRevoked, Name: kexp.Name, Namespace: kexp.Namespace, Port: u.Name, PreviousURL: u.URL})
+		}
+	}
+
+	return pending
+}
+
+// applyNotification updates the urls a target has been notified of
+func applyNotification(notified []kubexposev1.PortURL, n notification) []kubexposev1.PortURL {
+	var urls []kubexposev1.PortURL
+	for _, u := range notified {
+		if u.Name != n.Port {
+			urls = append(urls, u)
+		}
+	}
+	if n.URL != "" {
+		urls = append(urls, kubexposev1.PortURL{Name: n.Port, URL: n.URL})
+	}
+	return urls
+}
+
+func urlFor(urls []kubexposev1.PortURL, port string) string {
+	for _, u := range urls {
+		if u.Name == port {
+			return u.URL
+		}
+	}
+	return ""
+}
+
+// notifyBackoff returns how long to wait after the given number of failed attempts
+func notifyBackoff(attempts int32) time.Duration {
+	delay := notifyRetryDelay
+	for i := int32(1); i < attempts; i++ {
+		delay *= 2
+		if delay >= maxNotifyRetryDelay {
+			return maxNotifyRetryDelay
+		}
+	}
+	return delay
+}
+
+// deliver posts a notification to the target. a 2xx response means that it has been delivered.
+// other client errors (apart from 408 and 429) are not retried
+func (r *KubexposeReconciler) deliver(ctx context.Context, kexp *kubexposev1.Kubexpose, target *kubexposev1.NotifyTarget, n notification) error {
+	body, err := notificationBody(target, n)
+	if err != nil {
+		return err
+	}
+
+	headers, err := r.notifyHeaders(ctx, kexp, target)
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, target.URL, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{msg: "invalid url: " + err.Error()}
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("User-Agent", "kubexpose-operator")
+	for name, value := range headers {
+		httpReq.Header.Set(name, string(value))
+	}
+
+	resp, err := notifyClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// drain the body so that the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	msg := fmt.Sprintf("%s responded with %s", target.URL, resp.Status)
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return permanentError{msg: msg}
+	}
+	return stderror.New(msg)
+}
+
+// notificationBody renders the template of the target, or the notification itself if there is no template
+func notificationBody(target *kubexposev1.NotifyTarget, n notification) ([]byte, error) {
+	if target.Template == "" {
+		return json.Marshal(n)
+	}
+
+	tmpl, err := kubexposev1.ParseNotifyTemplate(target.Name, target.Template)
+	if err != nil {
+		return nil, permanentError{msg: "invalid template: " + err.Error()}
+	}
+
+	var body bytes.Buffer
+	err = tmpl.Execute(&body, n)
+	if err != nil {
+		return nil, permanentError{msg: "failed to render template: " + err.Error()}
+	}
+	if !json.Valid(body.Bytes()) {
+		return nil, permanentError{msg: "template did not render valid JSON"}
+	}
+	return body.Bytes(), nil
+}
+
+// notifyHeaders returns the headers in the Secret referred to by the target (if any). the Secret might be created later, hence the error is retried
+func (r *KubexposeReconciler) notifyHeaders(ctx context.Context, kexp *kubexposev1.Kubexpose, target *kubexposev1.NotifyTarget) (map[string][]byte, error) {
+	if target.HeadersSecretName == "" {
+		return nil, nil
+	}
+
+	var secret corev1.Secret
+	err := r.Get(ctx, types.NamespacedName{Namespace: kexp.ExposedNamespace(), Name: target.HeadersSecretName}, &secret)
+	if err != nil {
+		if client.IgnoreNotFound(err) == nil {
+			return nil, fmt.Errorf("secret %s/%s does not exist", kexp.ExposedNamespace(), target.HeadersSecretName)
+		}
+		return nil, err
+	}
+	return secret.Data, nil
+}
@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	kubexposev1 "github.com/abhirockzz/kubexpose-operator/api/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func TestNotify(t *testing.T) {
+	var bodies []string
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		bodies = append(bodies, string(body))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	// the test server listens on the loopback address, which notifyClient refuses to connect to
+	defaultClient := notifyClient
+	notifyClient = server.Client()
+	defer func() { notifyClient = defaultClient }()
+
+	ctx := context.Background()
+	r := &KubexposeReconciler{Recorder: record.NewFakeRecorder(10)}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "app"}}
+
+	kexp := &kubexposev1.Kubexpose{
+		ObjectMeta: metaV1.ObjectMeta{Namespace: "default", Name: "app"},
+		Spec: kubexposev1.KubexposeSpec{
+			Notify: []kubexposev1.NotifyTarget{
+				{Name: "all", URL: server.URL},
+				{Name: "chat", URL: server.URL, Template: `{"text": {{ printf "%s is at %s" .Name .URL | json }}}`, Events: []kubexposev1.NotifyEvent{kubexposev1.NotifyURLAssigned}},
+			},
+		},
+	}
+
+	// assigned - both targets are notified
+	kexp.Status.URLs = []kubexposev1.PortURL{{Name: "default", URL: "https://one.ngrok.io"}}
+	if wait := r.notify(ctx, req, kexp); wait != 0 {
+		t.Fatalf("unexpected retry after %s", wait)
+	}
+	if len(bodies) != 2 {
+		t.Fatalf("expected 2 notifications, got %d", len(bodies))
+	}
+	var n notification
+	if err := json.Unmarshal([]byte(bodies[0]), &n); err != nil {
+		t.Fatal(err)
+	}
+	if n != (notification{Event: kubexposev1.NotifyURLAssigned, Name: "app", Namespace: "default", Port: "default", URL: "https://one.ngrok.io"}) {
+		t.Errorf("unexpected notification %+v", n)
+	}
+	if bodies[1] != `{"text": "app is at https://one.ngrok.io"}` {
+		t.Errorf("unexpected templated body %s", bodies[1])
+	}
+	for _, s := range kexp.Status.Notifications {
+		if s.State != kubexposev1.NotificationDelivered || len(s.URLs) != 1 {
+			t.Errorf("unexpected status %+v", s)
+		}
+	}
+
+	// nothing changed - nothing to notify
+	bodies = nil
+	r.notify(ctx, req, kexp)
+	if len(bodies) != 0 {
+		t.Fatalf("expected no notifications, got %d", len(bodies))
+	}
+
+	// changed - chat is only notified of assigned urls, and the target is failing
+	status = http.StatusServiceUnavailable
+	kexp.Status.URLs = []kubexposev1.PortURL{{Name: "default", URL: "https://two.ngrok.io"}}
+	if wait := r.notify(ctx, req, kexp); wait != notifyRetryDelay {
+		t.Errorf("expected retry after %s, got %s", notifyRetryDelay, wait)
+	}
+	if len(bodies) != 1 {
+		t.Fatalf("expected 1 notification, got %d", len(bodies))
+	}
+	all, chat := kexp.Status.Notifications[0], kexp.Status.Notifications[1]
+	if all.State != kubexposev1.NotificationRetrying || all.Attempts != 1 || all.URLs[0].URL != "https://one.ngrok.io" {
+		t.Errorf("unexpected status %+v", all)
+	}
+	if chat.State != kubexposev1.NotificationDelivered || chat.URLs[0].URL != "https://two.ngrok.io" {
+		t.Errorf("unexpected status %+v", chat)
+	}
+
+	// the retry is not due yet
+	if wait := r.notify(ctx, req, kexp); wait <= 0 || len(bodies) != 1 {
+		t.Errorf("expected no attempt before the backoff, got %d notifications", len(bodies))
+	}
+
+	// rejected - not retried
+	status = http.StatusBadRequest
+	past := metaV1.NewTime(time.Now().Add(-time.Hour))
+	kexp.Status.Notifications[0].LastAttemptTime = &past
+	if wait := r.notify(ctx, req, kexp); wait != 0 {
+		t.Errorf("unexpected retry after %s", wait)
+	}
+	all = kexp.Status.Notifications[0]
+	if all.State != kubexposev1.NotificationFailed || all.Attempts != 2 || all.URLs[0].URL != "https://two.ngrok.io" {
+		t.Errorf("unexpected status %+v", all)
+	}
+
+	// revoked - the removed target is dropped from the status
+	status = http.StatusOK
+	bodies = nil
+	kexp.Spec.Notify = kexp.Spec.Notify[:1]
+	kexp.Status.URLs = nil
+	r.notify(ctx, req, kexp)
+	if len(bodies) != 1 {
+		t.Fatalf("expected 1 notification, got %d", len(bodies))
+	}
+	var revoked notification
+	if err := json.Unmarshal([]byte(bodies[0]), &revoked); err != nil {
+		t.Fatal(err)
+	}
+	if revoked != (notification{Event: kubexposev1.NotifyURLRevoked, Name: "app", Namespace: "default", Port: "default", PreviousURL: "https://two.ngrok.io"}) {
+		t.Errorf("unexpected notification %+v", revoked)
+	}
+	if len(kexp.Status.Notifications) != 1 || len(kexp.Status.Notifications[0].URLs) != 0 {
+		t.Errorf("unexpected status %+v", kexp.Status.Notifications)
+	}
+}
+
+func TestNotifyBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int32
+		want     time.Duration
+	}{
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{4, 40 * time.Second},
+		{10, maxNotifyRetryDelay},
+	}
+	for _, tc := range tests {
+		if got := notifyBackoff(tc.attempts); got != tc.want {
+			t.Errorf("notifyBackoff(%d) = %s, want %s", tc.attempts, got, tc.want)
+		}
+	}
+}
+
+func TestNotifyInternalAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		t.Error("internal address was notified")
+	}))
+	defer server.Close()
+
+	r := &KubexposeReconciler{}
+	kexp := &kubexposev1.Kubexpose{ObjectMeta: metaV1.ObjectMeta{Namespace: "default", Name: "app"}}
+	target := &kubexposev1.NotifyTarget{Name: "local", URL: server.URL}
+
+	err := r.deliver(context.Background(), kexp, target, notification{Event: kubexposev1.NotifyURLAssigned, URL: "https://one.ngrok.io"})
+	if !isPermanent(err) {
+		t.Errorf("expected a permanent error, got %v", err)
+	}
+
+	for _, address := range []string{"169.254.169.254:80", "10.96.0.1:443", "[::1]:80", "0.0.0.0:80"} {
+		if err := checkNotifyAddress("tcp", address, nil); err == nil {
+			t.Errorf("%s is allowed", address)
+		}
+	}
+	if err := checkNotifyAddress("tcp", "93.184.216.34:443", nil); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,280 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	kubexposev1 "github.com/abhirockzz/kubexpose-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// the Service and tunnel Deployment are labelled with the Kubexpose resource they belong to.
+// owner references do not work across namespaces - these labels are used to track the objects instead
+const (
+	ownerNameLabel      = "kubexpose.kubexpose.io/owner-name"
+	ownerNamespaceLabel = "kubexpose.kubexpose.io/owner-namespace"
+	// the name of the Kubexpose resource might be too long for a label value. the label is used to select the objects,
+	// while the annotation has the name as is
+	ownerNameAnnotation = "kubexpose.kubexpose.io/owner-name"
+
+	// tears down the tunnel and cleans up the Service and tunnel Deployment in the target namespace
+	cleanupFinalizer = "kubexpose.kubexpose.io/cleanup"
+
+	// hash of the tunnel provider configuration. set on the tunnel Pod template so that the Pods are restarted when it changes
+	configHashAnnotation = "kubexpose.kubexpose.io/config-hash"
+
+	// hash of the tunnel Pod template. set on the tunnel Deployment to find out whether the template has to be updated
+	templateHashAnnotation = "kubexpose.kubexpose.io/template-hash"
+)
+
+func ownerLabels(kexp *kubexposev1.Kubexpose) map[string]string {
+	return map[string]string{
+		ownerNameLabel:      labelValue(kexp.Name),
+		ownerNamespaceLabel: kexp.Namespace,
+	}
+}
+
+// labelValue returns the name as is if it's a valid label value. longer names (e.g. of a Kubexpose resource or source) are truncated
+// and suffixed with their hash, so that they remain unique
+func labelValue(name string) string {
+	if len(name) <= validation.LabelValueMaxLength {
+		return name
+	}
+
+	hash := sha256.Sum256([]byte(name))
+	suffix := hex.EncodeToString(hash[:])[:10]
+	return strings.TrimRight(name[:validation.LabelValueMaxLength-len(suffix)-1], "-.") + "-" + suffix
+}
+
+// setOwnership labels the object with the Kubexpose resource it belongs to.
+// the Kubexpose resource is also set as the owner and controller if they are in the same namespace.
+// an error is returned if the object belongs to another Kubexpose resource, or if it already exists and was not created by kubexpose -
+// it would be overwritten and eventually deleted along with the Kubexpose resource otherwise
+func (r *KubexposeReconciler) setOwnership(kexp *kubexposev1.Kubexpose, obj client.Object) error {
+	objLabels := obj.GetLabels()
+
+	if obj.GetResourceVersion() != "" {
+		if objLabels[ownerNameLabel] != "" {
+			if objLabels[ownerNameLabel] != labelValue(kexp.Name) || objLabels[ownerNamespaceLabel] != kexp.Namespace {
+				return fmt.Errorf("%s/%s belongs to kubexpose %s/%s", obj.GetNamespace(), obj.GetName(), objLabels[ownerNamespaceLabel], ownerName(obj))
+			}
+		} else if !metaV1.IsControlledBy(obj, kexp) {
+			// objects created before the labels were introduced only have the owner reference
+			return permanentError{msg: fmt.Sprintf("%s/%s already exists and was not created by kubexpose", obj.GetNamespace(), obj.GetName())}
+		}
+	}
+
+	if objLabels == nil {
+		objLabels = map[string]string{}
+	}
+	for k, v := range ownerLabels(kexp) {
+		objLabels[k] = v
+	}
+	obj.SetLabels(objLabels)
+
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[ownerNameAnnotation] = kexp.Name
+	obj.SetAnnotations(annotations)
+
+	if kexp.IsCrossNamespace() {
+		return nil
+	}
+
+	// Set Kubexpose instance as the owner and controller
+	return ctrl.SetControllerReference(kexp, obj, r.Scheme)
+}
+
+// cleanup deletes the Services, tunnel Deployments, ConfigMaps and Secrets (in any namespace) labelled with the Kubexpose resource.
+// this is needed since there is no garbage collection for objects created in a namespace other than that of the Kubexpose resource
+func (r *KubexposeReconciler) cleanup(ctx context.Context, req ctrl.Request, kexp *kubexposev1.Kubexpose) error {
+	logger := log.Log.WithValues("kubexpose", req.NamespacedName)
+
+	selector := client.MatchingLabels(ownerLabels(kexp))
+
+	var deployments appsv1.DeploymentList
+	err := r.List(ctx, &deployments, selector)
+	if err != nil {
+		return err
+	}
+
+	for i := range deployments.Items {
+		dep := &deployments.Items[i]
+		logger.Info("deleting tunnel deployment", "namespace", dep.Namespace, "name", dep.Name)
+		err = r.Delete(ctx, dep, client.PropagationPolicy("Background"))
+		if client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+
+	var services corev1.ServiceList
+	err = r.List(ctx, &services, selector)
+	if err != nil {
+		return err
+	}
+
+	for i := range services.Items {
+		svc := &services.Items[i]
+		logger.Info("deleting service", "namespace", svc.Namespace, "name", svc.Name)
+		err = r.Delete(ctx, svc)
+		if client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+
+	var configMaps corev1.ConfigMapList
+	err = r.List(ctx, &configMaps, selector)
+	if err != nil {
+		return err
+	}
+
+	for i := range configMaps.Items {
+		cm := &configMaps.Items[i]
+		logger.Info("deleting configmap", "namespace", cm.Namespace, "name", cm.Name)
+		err = r.Delete(ctx, cm)
+		if client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+
+	// only the Secrets with the published url are labelled
+	secrets, err := r.listSecretMetadata(ctx, selector)
+	if err != nil {
+		return err
+	}
+
+	for i := range secrets {
+		secret := &secrets[i]
+		logger.Info("deleting secret", "namespace", secret.Namespace, "name", secret.Name)
+		err = r.Delete(ctx, secret)
+		if client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteStale deletes the labelled objects which don't match the current source and target namespace. published ones are left to deleteUnpublished
+func (r *KubexposeReconciler) deleteStale(ctx context.Context, req ctrl.Request, kexp *kubexposev1.Kubexpose) error {
+	logger := log.Log.WithValues("kubexpose", req.NamespacedName)
+
+	selector := client.MatchingLabels(ownerLabels(kexp))
+	namespace := kexp.ExposedNamespace()
+
+	// an existing Service (used as the source) is not labelled, hence there is nothing to keep
+	var keepService types.NamespacedName
+	if kexp.Spec.SourceRef().Kind != kubexposev1.SourceKindService {
+		keepService = types.NamespacedName{Namespace: namespace, Name: serviceNameFor(kexp)}
+	}
+	keepDeployment := types.NamespacedName{Namespace: namespace, Name: deploymentNameFor(kexp)}
+	keepConfigMap := types.NamespacedName{Namespace: namespace, Name: configMapNameFor(kexp)}
+
+	var deployments appsv1.DeploymentList
+	err := r.List(ctx, &deployments, selector)
+	if err != nil {
+		return err
+	}
+
+	for i := range deployments.Items {
+		dep := &deployments.Items[i]
+		if (types.NamespacedName{Namespace: dep.Namespace, Name: dep.Name}) == keepDeployment {
+			continue
+		}
+		logger.Info("deleting stale tunnel deployment", "namespace", dep.Namespace, "name", dep.Name)
+		err = r.Delete(ctx, dep, client.PropagationPolicy("Background"))
+		if client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+
+	var services corev1.ServiceList
+	err = r.List(ctx, &services, selector)
+	if err != nil {
+		return err
+	}
+
+	for i := range services.Items {
+		svc := &services.Items[i]
+		if (types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}) == keepService {
+			continue
+		}
+		logger.Info("deleting stale service", "namespace", svc.Namespace, "name", svc.Name)
+		err = r.Delete(ctx, svc)
+		if client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+
+	var configMaps corev1.ConfigMapList
+	err = r.List(ctx, &configMaps, selector)
+	if err != nil {
+		return err
+	}
+
+	for i := range configMaps.Items {
+		cm := &configMaps.Items[i]
+		if cm.Labels[publishedLabel] == "true" || (types.NamespacedName{Namespace: cm.Namespace, Name: cm.Name}) == keepConfigMap {
+			continue
+		}
+		logger.Info("deleting stale configmap", "namespace", cm.Namespace, "name", cm.Name)
+		err = r.Delete(ctx, cm)
+		if client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// mapToKubexpose maps a Service, tunnel Deployment, ConfigMap or published Secret to the Kubexpose resource it belongs to
+func mapToKubexpose(obj client.Object) []reconcile.Request {
+	objLabels := obj.GetLabels()
+
+	name := ownerName(obj)
+	namespace := objLabels[ownerNamespaceLabel]
+	if name == "" || namespace == "" {
+		return nil
+	}
+
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}}
+}
+
+// ownedByKubexpose tells whether the object is labelled with the Kubexpose resource it belongs to
+func ownedByKubexpose(obj client.Object) bool {
+	return obj.GetLabels()[ownerNamespaceLabel] != ""
+}
+
+// mapAll combines the requests of the map functions. duplicates are taken care of by the work queue
+func mapAll(fns ...handler.MapFunc) handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		var requests []reconcile.Request
+		for _, fn := range fns {
+			requests = append(requests, fn(obj)...)
+		}
+		return requests
+	}
+}
+
+// ownerName returns the name of the Kubexpose resource the object belongs to. objects created before the
+// annotation was introduced only have the label
+func ownerName(obj client.Object) string {
+	if name := obj.GetAnnotations()[ownerNameAnnotation]; name != "" {
+		return name
+	}
+	return obj.GetLabels()[ownerNameLabel]
+}
@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	kubexposev1 "github.com/abhirockzz/kubexpose-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestDeleteStale(t *testing.T) {
+	kexp := &kubexposev1.Kubexpose{
+		ObjectMeta: metaV1.ObjectMeta{Namespace: "default", Name: "app"},
+		Spec:       kubexposev1.KubexposeSpec{Source: &kubexposev1.SourceReference{Kind: kubexposev1.SourceKindDeployment, Name: "nginx-v2"}, TargetNamespace: "apps"},
+	}
+	owned := func(obj client.Object, namespace, name string) client.Object {
+		obj.SetNamespace(namespace)
+		obj.SetName(name)
+		obj.SetLabels(ownerLabels(kexp))
+		return obj
+	}
+
+	objects := []client.Object{
+		// current
+		owned(&appsv1.Deployment{}, "apps", "nginx-v2-expose-app"),
+		owned(&corev1.Service{}, "apps", "nginx-v2-svc-app"),
+		owned(&corev1.ConfigMap{}, "apps", "nginx-v2-expose-app-config"),
+		// previous source
+		owned(&appsv1.Deployment{}, "apps", "nginx-expose-app"),
+		owned(&corev1.Service{}, "apps", "nginx-svc-app"),
+		owned(&corev1.ConfigMap{}, "apps", "nginx-expose-app-config"),
+		// previous target namespace
+		owned(&appsv1.Deployment{}, "default", "nginx-v2-expose-app"),
+		// published
+		&corev1.ConfigMap{ObjectMeta: metaV1.ObjectMeta{Namespace: "apps", Name: "nginx-urls", Labels: map[string]string{ownerNameLabel: "app", ownerNamespaceLabel: "default", publishedLabel: "true"}}},
+		// another Kubexpose resource
+		&appsv1.Deployment{ObjectMeta: metaV1.ObjectMeta{Namespace: "apps", Name: "nginx-expose-other", Labels: map[string]string{ownerNameLabel: "other", ownerNamespaceLabel: "default"}}},
+	}
+
+	ctx := context.Background()
+	r := &KubexposeReconciler{Client: fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(objects...).Build()}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "app"}}
+
+	if err := r.deleteStale(ctx, req, kexp); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]bool{
+		"apps/nginx-v2-expose-app":        true,
+		"apps/nginx-v2-svc-app":           true,
+		"apps/nginx-v2-expose-app-config": true,
+		"apps/nginx-expose-app":           false,
+		"apps/nginx-svc-app":              false,
+		"apps/nginx-expose-app-config":    false,
+		"default/nginx-v2-expose-app":     false,
+		"apps/nginx-urls":                 true,
+		"apps/nginx-expose-other":         true,
+	}
+	for _, obj := range objects {
+		key := obj.GetNamespace() + "/" + obj.GetName()
+		err := r.Get(ctx, client.ObjectKeyFromObject(obj), obj.DeepCopyObject().(client.Object))
+		if exists := err == nil; exists != want[key] {
+			t.Errorf("%T %s exists: %v, want %v (%v)", obj, key, exists, want[key], err)
+		}
+	}
+}
+
+func TestLabelValue(t *testing.T) {
+	if got := labelValue("nginx"); got != "nginx" {
+		t.Errorf("short name changed to %s", got)
+	}
+
+	long := strings.Repeat("a", 60) + ".example-service"
+	other := strings.Repeat("a", 60) + ".example-gateway"
+
+	got := labelValue(long)
+	if errs := validation.IsValidLabelValue(got); len(errs) > 0 {
+		t.Errorf("invalid label value %s: %v", got, errs)
+	}
+	if got == labelValue(other) {
+		t.Errorf("names with the same prefix have the same label value %s", got)
+	}
+
+	kexp := &kubexposev1.Kubexpose{ObjectMeta: metaV1.ObjectMeta{Namespace: "default", Name: long}}
+	r := &KubexposeReconciler{}
+	svc := &corev1.Service{ObjectMeta: metaV1.ObjectMeta{Namespace: "apps", Name: "nginx"}}
+	kexp.Spec.TargetNamespace = "apps"
+	if err := r.setOwnership(kexp, svc); err != nil {
+		t.Fatal(err)
+	}
+	requests := mapToKubexpose(svc)
+	if len(requests) != 1 || requests[0].Name != long || requests[0].Namespace != "default" {
+		t.Errorf("unexpected requests %v", requests)
+	}
+}
+
+func TestSetOwnershipExisting(t *testing.T) {
+	kexp := &kubexposev1.Kubexpose{ObjectMeta: metaV1.ObjectMeta{Namespace: "default", Name: "app", UID: "1234"}}
+	other := &kubexposev1.Kubexpose{ObjectMeta: metaV1.ObjectMeta{Namespace: "default", Name: "other", UID: "5678"}}
+	controlledBy := func(owner *kubexposev1.Kubexpose) []metaV1.OwnerReference {
+		return []metaV1.OwnerReference{*metaV1.NewControllerRef(owner, kubexposev1.GroupVersion.WithKind("Kubexpose"))}
+	}
+
+	tests := []struct {
+		name    string
+		svc     metaV1.ObjectMeta
+		wantErr bool
+	}{
+		{name: "new", svc: metaV1.ObjectMeta{}},
+		{name: "labelled", svc: metaV1.ObjectMeta{ResourceVersion: "1", Labels: ownerLabels(kexp)}},
+		{name: "owner reference only", svc: metaV1.ObjectMeta{ResourceVersion: "1", OwnerReferences: controlledBy(kexp)}},
+		{name: "another kubexpose", svc: metaV1.ObjectMeta{ResourceVersion: "1", Labels: ownerLabels(other)}, wantErr: true},
+		{name: "owner reference of another kubexpose", svc: metaV1.ObjectMeta{ResourceVersion: "1", OwnerReferences: controlledBy(other)}, wantErr: true},
+		{name: "not created by kubexpose", svc: metaV1.ObjectMeta{ResourceVersion: "1", Labels: map[string]string{"app": "nginx"}}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &KubexposeReconciler{Scheme: testScheme(t)}
+			svc := &corev1.Service{ObjectMeta: tc.svc}
+			svc.Namespace, svc.Name = "default", "nginx-svc-app"
+
+			err := r.setOwnership(kexp, svc)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("error %v, want error %v", err, tc.wantErr)
+			}
+			if err != nil && svc.Labels[ownerNameLabel] != tc.svc.Labels[ownerNameLabel] {
+				t.Errorf("labels changed to %v", svc.Labels)
+			}
+		})
+	}
+}
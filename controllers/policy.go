@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	kubexposev1 "github.com/abhirockzz/kubexpose-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// index of Kubexpose resources by the namespace in which they run the tunnel
+const exposedNamespaceIndexField = ".spec.targetNamespace"
+
+// checkPolicies tells whether the tunnel has to be stopped since the Kubexpose resource does not comply with the KubexposePolicy resources,
+// or since the namespace already has as many tunnels as the policies allow
+func (r *KubexposeReconciler) checkPolicies(ctx context.Context, req ctrl.Request, kexp *kubexposev1.Kubexpose) (*tunnelStop, error) {
+	logger := log.Log.WithValues("kubexpose", req.NamespacedName)
+
+	var policies kubexposev1.KubexposePolicyList
+	err := r.List(ctx, &policies)
+	if err != nil {
+		logger.Error(err, "failed to list kubexpose policies")
+		return nil, err
+	}
+
+	if len(policies.Items) == 0 {
+		removeCondition(kexp, kubexposev1.ConditionPolicyCompliant)
+		return nil, nil
+	}
+
+	var violations field.ErrorList
+	for i := range policies.Items {
+		violations = append(violations, policies.Items[i].Violations(kexp, time.Now())...)
+	}
+
+	if len(violations) > 0 {
+		message := violations.ToAggregate().Error()
+		if condition := meta.FindStatusCondition(kexp.Status.Conditions, kubexposev1.ConditionPolicyCompliant); condition == nil || condition.Message != message {
+			logger.Info("kubexpose resource does not comply with policy", "violations", message)
+			r.Recorder.Event(kexp, corev1.EventTypeWarning, eventPolicyViolation, message)
+		}
+		setCondition(kexp, kubexposev1.ConditionPolicyCompliant, metaV1.ConditionFalse, reasonPolicyViolation, message)
+		return &tunnelStop{reason: reasonPolicyViolation, message: message}, nil
+	}
+
+	var kexps kubexposev1.KubexposeList
+	err = r.List(ctx, &kexps, client.MatchingFields{exposedNamespaceIndexField: kexp.ExposedNamespace()})
+	if err != nil {
+		logger.Error(err, "failed to list kubexpose resources in namespace", "namespace", kexp.ExposedNamespace())
+		return nil, err
+	}
+	running := kubexposev1.TunnelsAhead(kexp, kexps.Items)
+
+	for _, policy := range policies.Items {
+		if policy.TunnelLimitReached(running) {
+			message := fmt.Sprintf("policy %s allows at most %d tunnels in namespace %s", policy.Name, *policy.Spec.MaxTunnelsPerNamespace, kexp.ExposedNamespace())
+			setCondition(kexp, kubexposev1.ConditionPolicyCompliant, metaV1.ConditionFalse, reasonTunnelLimitReached, message)
+			return &tunnelStop{reason: reasonTunnelLimitReached, message: message}, nil
+		}
+	}
+
+	setCondition(kexp, kubexposev1.ConditionPolicyCompliant, metaV1.ConditionTrue, reasonPolicyCompliant, fmt.Sprintf("complies with %d policies", len(policies.Items)))
+	return nil, nil
+}
+
+func indexExposedNamespace(obj client.Object) []string {
+	return []string{obj.(*kubexposev1.Kubexpose).ExposedNamespace()}
+}
+
+// mapPolicyToKubexpose maps a KubexposePolicy to all the Kubexpose resources, since each one of them has to comply with it
+func (r *KubexposeReconciler) mapPolicyToKubexpose(obj client.Object) []reconcile.Request {
+	var kexps kubexposev1.KubexposeList
+	err := r.List(context.Background(), &kexps)
+	if err != nil {
+		log.Log.Error(err, "failed to list kubexpose resources for policy", "name", obj.GetName())
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, kexp := range kexps.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: kexp.Namespace, Name: kexp.Name}})
+	}
+	return requests
+}
+
+// mapToTunnelNamespace maps a Kubexpose resource to the others which run their tunnel in the same namespace. when a tunnel starts or stops,
+// the others might have to start or stop theirs to stay within the number of tunnels per namespace allowed by the policies
+func (r *KubexposeReconciler) mapToTunnelNamespace(obj client.Object) []reconcile.Request {
+	ctx := context.Background()
+
+	var policies kubexposev1.KubexposePolicyList
+	err := r.List(ctx, &policies)
+	if err != nil {
+		log.Log.Error(err, "failed to list kubexpose policies")
+		return nil
+	}
+
+	limited := false
+	for _, policy := range policies.Items {
+		if policy.Spec.MaxTunnelsPerNamespace != nil {
+			limited = true
+		}
+	}
+	if !limited {
+		return nil
+	}
+
+	kexp := obj.(*kubexposev1.Kubexpose)
+
+	var kexps kubexposev1.KubexposeList
+	err = r.List(ctx, &kexps, client.MatchingFields{exposedNamespaceIndexField: kexp.ExposedNamespace()})
+	if err != nil {
+		log.Log.Error(err, "failed to list kubexpose resources in namespace", "namespace", kexp.ExposedNamespace())
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, other := range kexps.Items {
+		if other.UID == kexp.UID {
+			continue
+		}
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: other.Namespace, Name: other.Name}})
+	}
+	return requests
+}
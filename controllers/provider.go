@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	kubexposev1 "github.com/abhirockzz/kubexpose-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// TunnelProvider is implemented by the tunnel vendors which Kubexpose can use to expose a Service over a public URL.
+// the provider is selected using spec.provider in the Kubexpose resource
+type TunnelProvider interface {
+	// Name is the value of spec.provider which selects this provider
+	Name() string
+
+	// Validate returns an error if the provider does not support the tunnels (e.g. the protocol)
+	Validate(kexp *kubexposev1.Kubexpose, cfg tunnelConfig) error
+
+	// ConfigData returns the contents of the ConfigMap mounted into the tunnel Pods. nil is returned if the provider does not need one
+	ConfigData(kexp *kubexposev1.Kubexpose, cfg tunnelConfig) (map[string]string, error)
+
+	// PodSpec builds the spec of the Pod(s) in the tunnel Deployment
+	PodSpec(kexp *kubexposev1.Kubexpose, cfg tunnelConfig) corev1.PodSpec
+
+	// DiscoverURLs returns the public URL assigned to each tunnel running in the Pod behind the agent, keyed by tunnel name
+	DiscoverURLs(ctx context.Context, agent tunnelAgent, cfg tunnelConfig) (map[string]string, error)
+
+	// HealthCheck returns an error if the tunnels running in the Pod behind the agent are not healthy
+	HealthCheck(ctx context.Context, agent tunnelAgent, cfg tunnelConfig) error
+
+	// SessionActive tells whether the tunnels running in the Pod behind the agent are still connected to the provider.
+	// used during teardown to make sure that the public url is no longer accessible
+	SessionActive(ctx context.Context, agent tunnelAgent, cfg tunnelConfig) (bool, error)
+}
+
+// urlNotReadyError means that the tunnel has not been assigned a public url yet. it's expected to be once the tunnel is up
+type urlNotReadyError struct {
+	msg string
+}
+
+func (e urlNotReadyError) Error() string {
+	return e.msg
+}
+
+// urlMalformedError means that the response of the tunnel provider, or the public url in it, could not be understood
+type urlMalformedError struct {
+	msg string
+}
+
+func (e urlMalformedError) Error() string {
+	return e.msg
+}
+
+// urlAmbiguousError means that more than one tunnel reported by the tunnel provider matches the tunnel for a port
+type urlAmbiguousError struct {
+	msg string
+}
+
+func (e urlAmbiguousError) Error() string {
+	return e.msg
+}
+
+// trafficReporter is implemented by the providers which keep track of the traffic through the tunnels
+type trafficReporter interface {
+	// Traffic returns the traffic through each tunnel running in the Pod behind the agent, keyed by tunnel name
+	Traffic(ctx context.Context, agent tunnelAgent, cfg tunnelConfig) (map[string]tunnelTraffic, error)
+}
+
+// tunnelTraffic is the traffic through a tunnel since it was started
+type tunnelTraffic struct {
+	// number of http requests. zero for tcp and tls tunnels
+	requests int64
+	// number of client connections
+	connections int64
+}
+
+// tunnel is run by the tunnel Deployment for each port in the Kubexpose spec
+type tunnel struct {
+	// name of the port
+	name string
+	// one of http, tcp or tls
+	protocol string
+	// <host>:<port> the tunnel points to
+	address string
+	// <host>:<port> of the Service port. differs from address if access is restricted by a proxy in the tunnel Pod
+	serviceAddress string
+	// reserved hostname or subdomain for the tunnel, if any
+	hostname  string
+	subdomain string
+}
+
+// tunnelConfig is what a provider needs to build the tunnel Deployment
+type tunnelConfig struct {
+	tunnels []tunnel
+	// name of the ConfigMap with the data returned by ConfigData
+	configMapName string
+	// authtoken for the provider. nil if the tunnel is to be started anonymously
+	authToken *corev1.SecretKeySelector
+	// region in which the tunnels are hosted. empty for the provider default
+	region string
+}
+
+// tunnelAgent provides access to the admin API of a running tunnel Pod
+type tunnelAgent interface {
+	// Get performs a HTTP GET on the given port and path of the tunnel Pod and returns the response body
+	Get(ctx context.Context, port int, path string) ([]byte, error)
+
+	// Logs returns the logs of a container in the tunnel Pod
+	Logs(ctx context.Context, container string) ([]byte, error)
+}
+
+const defaultProvider = kubexposev1.DefaultProvider
+
+var providers = map[string]TunnelProvider{}
+
+// registerProvider makes a TunnelProvider available for use in spec.provider
+func registerProvider(p TunnelProvider) {
+	providers[p.Name()] = p
+}
+
+// providerFor returns the TunnelProvider configured for the Kubexpose resource
+func providerFor(kexp *kubexposev1.Kubexpose) (TunnelProvider, error) {
+	name := kexp.Spec.Provider
+	if name == "" {
+		name = defaultProvider
+	}
+
+	p, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported tunnel provider %q", name)
+	}
+	return p, nil
+}
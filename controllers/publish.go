@@ -0,0 +1,261 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	kubexposev1 "github.com/abhirockzz/kubexpose-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// marks the ConfigMaps and Secrets to which the public url is published, to tell them apart from the tunnel ConfigMap
+	publishedLabel = "kubexpose.kubexpose.io/published"
+
+	// hash of the published urls. set on the Pod template of the source so that it's rolled out when they change
+	urlHashAnnotation = "kubexpose.kubexpose.io/url-hash"
+)
+
+// publish writes the public urls (as per the in-memory status) to the ConfigMap and Secret in spec.publishTo, and rolls out the source if they changed.
+// the ones which are not referred to anymore are deleted
+func (r *KubexposeReconciler) publish(ctx context.Context, req ctrl.Request, kexp *kubexposev1.Kubexpose) error {
+	logger := log.Log.WithValues("kubexpose", req.NamespacedName)
+
+	var published []string
+	var err error
+
+	if publishTo := kexp.Spec.PublishTo; publishTo != nil {
+		published, err = r.reconcilePublished(ctx, req, kexp, publishTo)
+		if err == nil && publishTo.RolloutSource && len(kexp.Status.URLs) > 0 {
+			err = r.rolloutSource(ctx, req, kexp)
+		}
+
+		if err != nil {
+			logger.Error(err, "failed to publish public url")
+			r.recordTransition(kexp, kubexposev1.ConditionPublished, reasonPublishFailed, corev1.EventTypeWarning, eventPublishFailed, "failed to publish public url: "+err.Error())
+			setCondition(kexp, kubexposev1.ConditionPublished, metaV1.ConditionFalse, reasonPublishFailed, err.Error())
+			return err
+		}
+
+		setCondition(kexp, kubexposev1.ConditionPublished, metaV1.ConditionTrue, reasonPublished, "public url published to "+strings.Join(published, ", "))
+	} else {
+		removeCondition(kexp, kubexposev1.ConditionPublished)
+	}
+
+	return r.deleteUnpublished(ctx, req, kexp)
+}
+
+// reconcilePublished creates (or updates) the ConfigMap and Secret with the public urls. the kind and name of each one is returned
+func (r *KubexposeReconciler) reconcilePublished(ctx context.Context, req ctrl.Request, kexp *kubexposev1.Kubexpose, publishTo *kubexposev1.PublishSpec) ([]string, error) {
+	logger := log.Log.WithValues("kubexpose", req.NamespacedName)
+
+	var published []string
+
+	if target := publishTo.ConfigMap; target != nil {
+		if target.Name == configMapNameFor(kexp) {
+			return nil, permanentError{msg: fmt.Sprintf("configmap %s is used for the tunnel configuration", target.Name)}
+		}
+
+		cm := &corev1.ConfigMap{ObjectMeta: metaV1.ObjectMeta{Name: target.Name, Namespace: kexp.ExposedNamespace()}}
+		op, err := controllerutil.CreateOrUpdate(ctx, r.Client, cm, func() error {
+			cm.Data = publishedData(kexp, target.Key)
+			return r.setPublishedOwnership(kexp, cm)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if op != controllerutil.OperationResultNone {
+			logger.Info("published configmap successfully "+string(op), "namespace", cm.Namespace, "name", cm.Name)
+		}
+		published = append(published, "configmap "+cm.Name)
+	}
+
+	if target := publishTo.Secret; target != nil {
+		secret := &corev1.Secret{ObjectMeta: metaV1.ObjectMeta{Name: target.Name, Namespace: kexp.ExposedNamespace()}}
+		op, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+			secret.Data = map[string][]byte{}
+			for k, v := range publishedData(kexp, target.Key) {
+				secret.Data[k] = []byte(v)
+			}
+			return r.setPublishedOwnership(kexp, secret)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if op != controllerutil.OperationResultNone {
+			logger.Info("published secret successfully "+string(op), "namespace", secret.Namespace, "name", secret.Name)
+		}
+		published = append(published, "secret "+secret.Name)
+	}
+
+	return published, nil
+}
+
+// setPublishedOwnership labels a published ConfigMap or Secret with the Kubexpose resource it belongs to.
+// existing objects are not taken over, unless they were published by kubexpose
+func (r *KubexposeReconciler) setPublishedOwnership(kexp *kubexposev1.Kubexpose, obj client.Object) error {
+	if obj.GetResourceVersion() != "" && obj.GetLabels()[publishedLabel] != "true" {
+		return permanentError{msg: fmt.Sprintf("%s/%s already exists and was not created by kubexpose", obj.GetNamespace(), obj.GetName())}
+	}
+
+	err := r.setOwnership(kexp, obj)
+	if err != nil {
+		return permanentError{msg: err.Error()}
+	}
+
+	obj.GetLabels()[publishedLabel] = "true"
+	return nil
+}
+
+// publishedData returns the public url (of the first port) with the given key, along with the url of each port if ports are specified.
+// it's empty if there is no public url e.g. the tunnel has been stopped
+func publishedData(kexp *kubexposev1.Kubexpose, key string) map[string]string {
+	if key == "" {
+		key = kubexposev1.DefaultPublishKey
+	}
+
+	data := map[string]string{}
+	if kexp.Status.PublicURL != "" {
+		data[key] = kexp.Status.PublicURL
+	}
+	if len(kexp.Spec.Ports) > 0 {
+		for _, u := range kexp.Status.URLs {
+			data[key+"-"+u.Name] = u.URL
+		}
+	}
+	return data
+}
+
+// deleteUnpublished deletes the ConfigMaps and Secrets which were published for the Kubexpose resource but are not in publishTo anymore
+func (r *KubexposeReconciler) deleteUnpublished(ctx context.Context, req ctrl.Request, kexp *kubexposev1.Kubexpose) error {
+	logger := log.Log.WithValues("kubexpose", req.NamespacedName)
+
+	var keepConfigMap, keepSecret types.NamespacedName
+	if publishTo := kexp.Spec.PublishTo; publishTo != nil {
+		if publishTo.ConfigMap != nil {
+			keepConfigMap = types.NamespacedName{Namespace: kexp.ExposedNamespace(), Name: publishTo.ConfigMap.Name}
+		}
+		if publishTo.Secret != nil {
+			keepSecret = types.NamespacedName{Namespace: kexp.ExposedNamespace(), Name: publishTo.Secret.Name}
+		}
+	}
+
+	selector := client.MatchingLabels(ownerLabels(kexp))
+	selector[publishedLabel] = "true"
+
+	var configMaps corev1.ConfigMapList
+	err := r.List(ctx, &configMaps, selector)
+	if err != nil {
+		return err
+	}
+	for i := range configMaps.Items {
+		cm := &configMaps.Items[i]
+		if (types.NamespacedName{Namespace: cm.Namespace, Name: cm.Name}) == keepConfigMap {
+			continue
+		}
+		logger.Info("deleting published configmap", "namespace", cm.Namespace, "name", cm.Name)
+		err = r.Delete(ctx, cm)
+		if client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+
+	secrets, err := r.listSecretMetadata(ctx, selector)
+	if err != nil {
+		return err
+	}
+	for i := range secrets {
+		secret := &secrets[i]
+		if (types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}) == keepSecret {
+			continue
+		}
+		logger.Info("deleting published secret", "namespace", secret.Namespace, "name", secret.Name)
+		err = r.Delete(ctx, secret)
+		if client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// listSecretMetadata lists the metadata of the Secrets using the MetadataReader, which does not reach out to the API server.
+// the kind is set on each one, so that they can be deleted
+func (r *KubexposeReconciler) listSecretMetadata(ctx context.Context, opts ...client.ListOption) ([]metaV1.PartialObjectMetadata, error) {
+	var secrets metaV1.PartialObjectMetadataList
+	secrets.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("SecretList"))
+
+	err := r.MetadataReader.List(ctx, &secrets, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range secrets.Items {
+		secrets.Items[i].SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("Secret"))
+	}
+	return secrets.Items, nil
+}
+
+// rolloutSource sets the hash of the public urls on the Pod template of the source, which rolls it out if they changed
+func (r *KubexposeReconciler) rolloutSource(ctx context.Context, req ctrl.Request, kexp *kubexposev1.Kubexpose) error {
+	logger := log.Log.WithValues("kubexpose", req.NamespacedName)
+
+	ref := kexp.Spec.SourceRef()
+	key := types.NamespacedName{Namespace: kexp.ExposedNamespace(), Name: ref.Name}
+
+	var obj client.Object
+	var template *corev1.PodTemplateSpec
+
+	switch ref.Kind {
+	case kubexposev1.SourceKindDeployment:
+		deployment := &appsv1.Deployment{}
+		obj, template = deployment, &deployment.Spec.Template
+	case kubexposev1.SourceKindStatefulSet:
+		statefulSet := &appsv1.StatefulSet{}
+		obj, template = statefulSet, &statefulSet.Spec.Template
+	case kubexposev1.SourceKindDaemonSet:
+		daemonSet := &appsv1.DaemonSet{}
+		obj, template = daemonSet, &daemonSet.Spec.Template
+	default:
+		return permanentError{msg: "source kind " + ref.Kind + " can't be rolled out"}
+	}
+
+	err := r.Get(ctx, key, obj)
+	if err != nil {
+		// the source is watched
+		return client.IgnoreNotFound(err)
+	}
+
+	urls := map[string]string{}
+	for _, u := range kexp.Status.URLs {
+		urls[u.Name] = u.URL
+	}
+	hash := configHash(urls)
+
+	if template.Annotations[urlHashAnnotation] == hash {
+		return nil
+	}
+
+	patch := client.MergeFrom(obj.DeepCopyObject().(client.Object))
+	if template.Annotations == nil {
+		template.Annotations = map[string]string{}
+	}
+	template.Annotations[urlHashAnnotation] = hash
+
+	err = r.Patch(ctx, obj, patch)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("rolling out source since the public url changed", "kind", ref.Kind, "name", ref.Name)
+	r.Recorder.Eventf(kexp, corev1.EventTypeNormal, eventSourceRolledOut, "rolling out %s %s since the public url changed", ref.Kind, ref.Name)
+	return nil
+}
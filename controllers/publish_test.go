@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	kubexposev1 "github.com/abhirockzz/kubexpose-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestPublishedData(t *testing.T) {
+	tests := []struct {
+		name   string
+		spec   kubexposev1.KubexposeSpec
+		status kubexposev1.KubexposeStatus
+		key    string
+		want   map[string]string
+	}{
+		{
+			name:   "single port",
+			spec:   kubexposev1.KubexposeSpec{PortToExpose: 80},
+			status: kubexposev1.KubexposeStatus{PublicURL: "https://one.ngrok.io", URLs: []kubexposev1.PortURL{{Name: "default", URL: "https://one.ngrok.io"}}},
+			want:   map[string]string{"url": "https://one.ngrok.io"},
+		},
+		{
+			name: "ports",
+			spec: kubexposev1.KubexposeSpec{Ports: []kubexposev1.PortSpec{{Name: "web", Port: 80}, {Name: "api", Port: 8080}}},
+			status: kubexposev1.KubexposeStatus{PublicURL: "https://one.ngrok.io", URLs: []kubexposev1.PortURL{
+				{Name: "web", URL: "https://one.ngrok.io"},
+				{Name: "api", URL: "https://two.ngrok.io"},
+			}},
+			key:  "PUBLIC_URL",
+			want: map[string]string{"PUBLIC_URL": "https://one.ngrok.io", "PUBLIC_URL-web": "https://one.ngrok.io", "PUBLIC_URL-api": "https://two.ngrok.io"},
+		},
+		{
+			name: "no url",
+			spec: kubexposev1.KubexposeSpec{PortToExpose: 80},
+			want: map[string]string{},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			kexp := &kubexposev1.Kubexpose{Spec: tc.spec, Status: tc.status}
+			if got := publishedData(kexp, tc.key); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("publishedData() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// secretReader only serves the metadata of the Secrets, as the cache does for the MetadataReader
+type secretReader struct {
+	client.Reader
+}
+
+func (r secretReader) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	if _, ok := list.(*metaV1.PartialObjectMetadataList); !ok {
+		return fmt.Errorf("%T is not cached", list)
+	}
+	return r.Reader.List(ctx, list, opts...)
+}
+
+func TestDeleteUnpublished(t *testing.T) {
+	kexp := &kubexposev1.Kubexpose{
+		ObjectMeta: metaV1.ObjectMeta{Namespace: "default", Name: "app"},
+		Spec: kubexposev1.KubexposeSpec{
+			SourceDeploymentName: "nginx",
+			PublishTo:            &kubexposev1.PublishSpec{Secret: &kubexposev1.PublishTarget{Name: "app-url"}},
+		},
+	}
+	published := func(name string) *corev1.Secret {
+		secret := &corev1.Secret{ObjectMeta: metaV1.ObjectMeta{Namespace: "default", Name: name, Labels: ownerLabels(kexp)}}
+		secret.Labels[publishedLabel] = "true"
+		return secret
+	}
+	unrelated := &corev1.Secret{ObjectMeta: metaV1.ObjectMeta{Namespace: "default", Name: "ngrok"}}
+
+	ctx := context.Background()
+	c := fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(published("app-url"), published("old-url"), unrelated).Build()
+	r := &KubexposeReconciler{Client: c, MetadataReader: secretReader{Reader: c}}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "app"}}
+
+	if err := r.deleteUnpublished(ctx, req, kexp); err != nil {
+		t.Fatal(err)
+	}
+
+	var secrets corev1.SecretList
+	if err := c.List(ctx, &secrets); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, secret := range secrets.Items {
+		names = append(names, secret.Name)
+	}
+	if want := []string{"app-url", "ngrok"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("secrets %v, want %v", names, want)
+	}
+}
@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"time"
+
+	kubexposev1 "github.com/abhirockzz/kubexpose-operator/api/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// checkSchedule tells whether the tunnel has to be stopped since none of the scheduled windows is open.
+// the returned duration is when the Kubexpose resource needs to be reconciled next - when a window opens or closes
+func (r *KubexposeReconciler) checkSchedule(req ctrl.Request, kexp *kubexposev1.Kubexpose) (*tunnelStop, time.Duration) {
+	logger := log.Log.WithValues("kubexpose", req.NamespacedName)
+
+	if kexp.Spec.Schedule == nil {
+		removeCondition(kexp, kubexposev1.ConditionWithinSchedule)
+		return nil, 0
+	}
+
+	open, next, err := kexp.Spec.Schedule.WindowState(time.Now())
+	if err != nil {
+		logger.Error(err, "invalid schedule")
+		// the tunnel is not run if it's not clear when it's supposed to
+		setCondition(kexp, kubexposev1.ConditionWithinSchedule, metaV1.ConditionFalse, reasonInvalidSchedule, err.Error())
+		return &tunnelStop{reason: reasonInvalidSchedule, message: err.Error()}, 0
+	}
+
+	if open {
+		setCondition(kexp, kubexposev1.ConditionWithinSchedule, metaV1.ConditionTrue, reasonWindowOpen, "window closes at "+next.Format(time.RFC3339))
+		return nil, time.Until(next)
+	}
+
+	message := "next window opens at " + next.Format(time.RFC3339)
+	setCondition(kexp, kubexposev1.ConditionWithinSchedule, metaV1.ConditionFalse, reasonWindowClosed, message)
+	return &tunnelStop{reason: reasonWindowClosed, message: message}, time.Until(next)
+}
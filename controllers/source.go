@@ -0,0 +1,200 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	kubexposev1 "github.com/abhirockzz/kubexpose-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// index of Kubexpose resources by their source - <kind>/<namespace>/<name>.
+// the name is left out for Pods which are selected using labels
+const sourceIndexField = ".spec.source"
+
+// exposedSource is the workload (or Service) referred to by the Kubexpose resource
+type exposedSource struct {
+	kind string
+	name string
+
+	// labels of the Pods to be exposed. used as the selector for the Service created by kubexpose
+	selector map[string]string
+
+	// ports of the existing Service. only applicable for kind Service
+	servicePorts []corev1.ServicePort
+}
+
+// existingService tells whether the source is an existing Service, in which case kubexpose does not create one
+func (s *exposedSource) existingService() bool {
+	return s.kind == kubexposev1.SourceKindService
+}
+
+// serviceNameFor returns the name of the Service which the tunnel points to
+func serviceNameFor(kexp *kubexposev1.Kubexpose) string {
+	source := kexp.Spec.SourceRef()
+	if source.Kind == kubexposev1.SourceKindService {
+		return source.Name
+	}
+	return fmt.Sprintf(serviceNameFormat, source.Name, kexp.Name)
+}
+
+// deploymentNameFor returns the name of the tunnel Deployment
+func deploymentNameFor(kexp *kubexposev1.Kubexpose) string {
+	return fmt.Sprintf(deploymentNameFormat, kexp.Spec.SourceRef().Name, kexp.Name)
+}
+
+// configMapNameFor returns the name of the ConfigMap with the tunnel provider configuration
+func configMapNameFor(kexp *kubexposev1.Kubexpose) string {
+	return deploymentNameFor(kexp) + "-config"
+}
+
+// fetchSource looks up the source in the target namespace and figures out the labels of the Pods to be exposed
+func (r *KubexposeReconciler) fetchSource(ctx context.Context, kexp *kubexposev1.Kubexpose) (*exposedSource, error) {
+	ref := kexp.Spec.SourceRef()
+	key := types.NamespacedName{Namespace: kexp.ExposedNamespace(), Name: ref.Name}
+
+	if ref.Name == "" {
+		return nil, permanentError{msg: "source is not specified"}
+	}
+
+	source := &exposedSource{kind: ref.Kind, name: ref.Name}
+
+	var err error
+	switch ref.Kind {
+	case kubexposev1.SourceKindDeployment:
+		var deployment appsv1.Deployment
+		err = r.Get(ctx, key, &deployment)
+		source.selector = workloadSelector(deployment.Spec.Selector, deployment.Spec.Template)
+	case kubexposev1.SourceKindStatefulSet:
+		var statefulSet appsv1.StatefulSet
+		err = r.Get(ctx, key, &statefulSet)
+		source.selector = workloadSelector(statefulSet.Spec.Selector, statefulSet.Spec.Template)
+	case kubexposev1.SourceKindDaemonSet:
+		var daemonSet appsv1.DaemonSet
+		err = r.Get(ctx, key, &daemonSet)
+		source.selector = workloadSelector(daemonSet.Spec.Selector, daemonSet.Spec.Template)
+	case kubexposev1.SourceKindReplicaSet:
+		var replicaSet appsv1.ReplicaSet
+		err = r.Get(ctx, key, &replicaSet)
+		source.selector = workloadSelector(replicaSet.Spec.Selector, replicaSet.Spec.Template)
+	case kubexposev1.SourceKindPod:
+		if len(ref.Selector) > 0 {
+			// a label selector matches Pods which might come and go - it's enough for one of them to exist
+			var pods corev1.PodList
+			err = r.List(ctx, &pods, client.InNamespace(key.Namespace), client.MatchingLabels(ref.Selector))
+			if err == nil && len(pods.Items) == 0 {
+				err = errors.NewNotFound(corev1.Resource("pods"), labels.SelectorFromSet(ref.Selector).String())
+			}
+			source.selector = ref.Selector
+		} else {
+			var pod corev1.Pod
+			err = r.Get(ctx, key, &pod)
+			source.selector = podSelector(pod.Labels)
+		}
+	case kubexposev1.SourceKindService:
+		var service corev1.Service
+		err = r.Get(ctx, key, &service)
+		source.selector = service.Spec.Selector
+		source.servicePorts = service.Spec.Ports
+	default:
+		return nil, permanentError{msg: "unsupported source kind " + ref.Kind}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(source.selector) == 0 && !source.existingService() {
+		return nil, permanentError{msg: fmt.Sprintf("no labels found for %s %s", ref.Kind, ref.Name)}
+	}
+
+	return source, nil
+}
+
+// workloadSelector returns the labels which can be used in a Service to select the Pods of a workload.
+// Services don't support set based requirements, hence the Pod template labels are used if the workload selector has them
+func workloadSelector(selector *metaV1.LabelSelector, template corev1.PodTemplateSpec) map[string]string {
+	if selector == nil {
+		return nil
+	}
+	if len(selector.MatchExpressions) > 0 {
+		return template.Labels
+	}
+	return selector.MatchLabels
+}
+
+// labels set by the workload controllers which change with every revision of the Pod template
+var podRevisionLabels = []string{appsv1.DefaultDeploymentUniqueLabelKey, appsv1.ControllerRevisionHashLabelKey, "pod-template-generation"}
+
+// podSelector returns the labels of a Pod which can be used in a Service to select it. the revision labels are dropped,
+// otherwise the Service would stop selecting the Pods once the workload is rolled out
+func podSelector(podLabels map[string]string) map[string]string {
+	selector := map[string]string{}
+	for k, v := range podLabels {
+		selector[k] = v
+	}
+	for _, k := range podRevisionLabels {
+		delete(selector, k)
+	}
+	return selector
+}
+
+func sourceKey(kind, namespace, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+// indexSource is used to index Kubexpose resources by their source
+func indexSource(obj client.Object) []string {
+	kexp := obj.(*kubexposev1.Kubexpose)
+	ref := kexp.Spec.SourceRef()
+
+	if ref.Kind == kubexposev1.SourceKindPod && len(ref.Selector) > 0 {
+		return []string{sourceKey(ref.Kind, kexp.ExposedNamespace(), "")}
+	}
+	return []string{sourceKey(ref.Kind, kexp.ExposedNamespace(), ref.Name)}
+}
+
+// mapSourceToKubexpose returns a function which maps a workload (or Service) of the given kind to the Kubexpose resources which expose it.
+// this way, the Kubexpose resources are reconciled when their source is created, changed or deleted
+func (r *KubexposeReconciler) mapSourceToKubexpose(kind string) handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		ctx := context.Background()
+
+		var kexps kubexposev1.KubexposeList
+		err := r.List(ctx, &kexps, client.MatchingFields{sourceIndexField: sourceKey(kind, obj.GetNamespace(), obj.GetName())})
+		if err != nil {
+			log.Log.Error(err, "failed to list kubexpose resources for source", "kind", kind, "namespace", obj.GetNamespace(), "name", obj.GetName())
+			return nil
+		}
+		matching := kexps.Items
+
+		if kind == kubexposev1.SourceKindPod {
+			var selecting kubexposev1.KubexposeList
+			err = r.List(ctx, &selecting, client.MatchingFields{sourceIndexField: sourceKey(kind, obj.GetNamespace(), "")})
+			if err != nil {
+				log.Log.Error(err, "failed to list kubexpose resources for pod", "namespace", obj.GetNamespace(), "name", obj.GetName())
+				return nil
+			}
+			for _, kexp := range selecting.Items {
+				if labels.SelectorFromSet(kexp.Spec.SourceRef().Selector).Matches(labels.Set(obj.GetLabels())) {
+					matching = append(matching, kexp)
+				}
+			}
+		}
+
+		var requests []reconcile.Request
+		for _, kexp := range matching {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: kexp.Namespace, Name: kexp.Name}})
+		}
+		return requests
+	}
+}
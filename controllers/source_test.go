@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	kubexposev1 "github.com/abhirockzz/kubexpose-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestFetchPodSource(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   map[string]string
+	}{
+		{"bare pod", map[string]string{"app": "nginx"}, map[string]string{"app": "nginx"}},
+		{"deployment pod", map[string]string{"app": "nginx", "pod-template-hash": "5d59d67564"}, map[string]string{"app": "nginx"}},
+		{
+			"statefulset pod",
+			map[string]string{"app": "redis", "controller-revision-hash": "redis-6f7c8b9d4", "statefulset.kubernetes.io/pod-name": "redis-0"},
+			map[string]string{"app": "redis", "statefulset.kubernetes.io/pod-name": "redis-0"},
+		},
+		{"daemonset pod", map[string]string{"app": "agent", "controller-revision-hash": "7b9f8c6d5", "pod-template-generation": "3"}, map[string]string{"app": "agent"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metaV1.ObjectMeta{Namespace: "default", Name: "app-0", Labels: tc.labels}}
+			kexp := &kubexposev1.Kubexpose{
+				ObjectMeta: metaV1.ObjectMeta{Namespace: "default", Name: "app"},
+				Spec:       kubexposev1.KubexposeSpec{Source: &kubexposev1.SourceReference{Kind: kubexposev1.SourceKindPod, Name: "app-0"}},
+			}
+			r := &KubexposeReconciler{Client: fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(pod).Build()}
+
+			source, err := r.fetchSource(context.Background(), kexp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(source.selector, tc.want) {
+				t.Errorf("selector %v, want %v", source.selector, tc.want)
+			}
+		})
+	}
+}
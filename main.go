@@ -19,15 +19,20 @@ package main
 import (
 	"flag"
 	"os"
+	// time zones of the scheduled windows are looked up even if the image does not have them
+	_ "time/tzdata"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
@@ -52,11 +57,18 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var defaultAuthSecret string
+	var maxConcurrentReconciles int
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&defaultAuthSecret, "default-auth-secret", "",
+		"Name of the Secret with the tunnel provider authtoken (key authtoken). "+
+			"It's looked up in the target namespace of Kubexpose resources which do not specify authSecretRef.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 4,
+		"Number of Kubexpose resources which are reconciled concurrently.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -72,6 +84,8 @@ func main() {
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "2a9da821.kubexpose.io",
+		// only a few Secrets (e.g. with the authtoken) are needed - they are read from the API server instead of caching all of them
+		ClientDisableCacheFor: []client.Object{&corev1.Secret{}},
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
@@ -79,12 +93,26 @@ func main() {
 	}
 
 	if err = (&controllers.KubexposeReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		Clientset: kubernetes.NewForConfigOrDie(mgr.GetConfig()),
+		Recorder:  mgr.GetEventRecorderFor("kubexpose-controller"),
+		// the Secrets are watched as metadata only, hence their metadata is in the cache
+		MetadataReader: mgr.GetCache(),
+
+		DefaultAuthSecret:       defaultAuthSecret,
+		MaxConcurrentReconciles: maxConcurrentReconciles,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Kubexpose")
 		os.Exit(1)
 	}
+	// webhooks can be disabled when running locally (make run) since there are no certificates
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&kubexposev1.Kubexpose{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Kubexpose")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {